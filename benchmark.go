@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// BenchmarkResult holds query latency percentiles measured by BenchmarkQuery
+type BenchmarkResult struct {
+	PutP50     time.Duration
+	PutP95     time.Duration
+	PutP99     time.Duration
+	ReserveP50 time.Duration
+	ReserveP95 time.Duration
+	ReserveP99 time.Duration
+}
+
+// BenchmarkQuery measures the latency of the queries used by Put and
+// Reserve by running each n times against the current database. The queue
+// is not modified: the Reserve query is only a SELECT and each Put is
+// rolled back.
+func (q *Queue) BenchmarkQuery(n int) (BenchmarkResult, error) {
+	var result BenchmarkResult
+	if n <= 0 {
+		return result, errors.New("n must be positive")
+	}
+
+	var tube string
+	err := q.db.QueryRow("SELECT tube FROM simple_queue LIMIT 1").Scan(&tube)
+	if err != nil && err != sql.ErrNoRows {
+		return result, err
+	}
+
+	query, args := q.reserveQuery(tube, 1, 0)
+	reserves := make([]time.Duration, n)
+	for i := range reserves {
+		start := time.Now()
+		rows, err := q.db.Query(query, args...)
+		if err != nil {
+			return result, err
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+		reserves[i] = time.Since(start)
+	}
+
+	puts := make([]time.Duration, n)
+	data := []byte("benchmark")
+	for i := range puts {
+		start := time.Now()
+		tx, err := q.db.Begin()
+		if err != nil {
+			return result, err
+		}
+		_, err = tx.Exec("INSERT into simple_queue (tube, created, modified, state, data, ttr, priority) VALUES(?, ?, ?, ?, ?, ?, ?)",
+			tube, toMillis(q.now()), toMillis(q.now()), STATE_READY, data, 1000, 0)
+		tx.Rollback()
+		if err != nil {
+			return result, err
+		}
+		puts[i] = time.Since(start)
+	}
+
+	result.PutP50, result.PutP95, result.PutP99 = percentiles(puts)
+	result.ReserveP50, result.ReserveP95, result.ReserveP99 = percentiles(reserves)
+	return result, nil
+}
+
+// percentiles returns the 50th, 95th, and 99th percentiles of d
+func percentiles(d []time.Duration) (time.Duration, time.Duration, time.Duration) {
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	at := func(p float64) time.Duration {
+		return d[int(p*float64(len(d)-1))]
+	}
+	return at(0.50), at(0.95), at(0.99)
+}
@@ -0,0 +1,30 @@
+package queue_test
+
+import (
+	"testing"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestBenchmarkQuery(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 10; i++ {
+			ok(t, q.Put("test", i, 600, []byte("testing")))
+		}
+
+		r, err := q.BenchmarkQuery(50)
+		ok(t, err)
+		assert(t, r.PutP50 > 0, "put p50 not set")
+		assert(t, r.ReserveP50 > 0, "reserve p50 not set")
+		assert(t, r.PutP50 <= r.PutP95 && r.PutP95 <= r.PutP99, "put percentiles out of order: %+v", r)
+		assert(t, r.ReserveP50 <= r.ReserveP95 && r.ReserveP95 <= r.ReserveP99, "reserve percentiles out of order: %+v", r)
+
+		// nothing was changed
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 10, len(jobs))
+		for _, j := range jobs {
+			equals(t, queue.STATE_READY, j.State)
+		}
+	})
+}
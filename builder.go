@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"errors"
+	"time"
+)
+
+// Builder accumulates options for a Queue. Create a Builder with Build.
+type Builder struct {
+	filename string
+	options  Options
+}
+
+// Build starts configuring a queue stored in filename
+func Build(filename string) *Builder {
+	return &Builder{
+		filename: filename,
+		options: Options{
+			Buffer:              4,
+			MaintenanceInterval: 3 * time.Second,
+			Clock:               time.Now,
+		},
+	}
+}
+
+// Buffer sets the size of the wait channel
+func (b *Builder) Buffer(n int) *Builder {
+	b.options.Buffer = n
+	return b
+}
+
+// MaintenanceInterval sets how often maintenance runs
+func (b *Builder) MaintenanceInterval(d time.Duration) *Builder {
+	b.options.MaintenanceInterval = d
+	return b
+}
+
+// WALMode enables SQLite write-ahead logging
+func (b *Builder) WALMode() *Builder {
+	WithWALMode()(&b.options)
+	return b
+}
+
+// BusyTimeout sets how long SQLite waits on a locked database
+func (b *Builder) BusyTimeout(d time.Duration) *Builder {
+	WithBusyTimeout(d)(&b.options)
+	return b
+}
+
+// MaxCapacity limits the number of jobs in the queue
+func (b *Builder) MaxCapacity(n int) *Builder {
+	WithMaxCapacity(n)(&b.options)
+	return b
+}
+
+// With applies arbitrary options
+func (b *Builder) With(opts ...Option) *Builder {
+	for _, opt := range opts {
+		opt(&b.options)
+	}
+	return b
+}
+
+// Create validates the configuration and opens the queue
+func (b *Builder) Create() (*Queue, error) {
+	switch {
+	case b.options.Buffer < 0:
+		return nil, errors.New("buffer must not be negative")
+	case b.options.MaintenanceInterval <= 0:
+		return nil, errors.New("maintenance interval must be positive")
+	case b.options.BusyTimeout < 0:
+		return nil, errors.New("busy timeout must not be negative")
+	case b.options.MaxCapacity < 0:
+		return nil, errors.New("max capacity must not be negative")
+	}
+	return open(b.filename, b.options)
+}
@@ -0,0 +1,50 @@
+package queue_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestBuilder(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+	defer os.Remove(file + "-wal")
+	defer os.Remove(file + "-shm")
+
+	q, err := queue.Build(file).
+		Buffer(4).
+		MaintenanceInterval(3 * time.Second).
+		WALMode().
+		BusyTimeout(5 * time.Second).
+		MaxCapacity(2).
+		Create()
+	ok(t, err)
+	defer q.Close()
+
+	o := q.Options()
+	equals(t, 4, o.Buffer)
+	equals(t, 3*time.Second, o.MaintenanceInterval)
+	equals(t, true, o.WALMode)
+	equals(t, 5*time.Second, o.BusyTimeout)
+	equals(t, 2, o.MaxCapacity)
+
+	ok(t, q.Put("test", 0, 600, []byte("one")))
+	ok(t, q.Put("test", 0, 600, []byte("two")))
+	equals(t, queue.ErrQueueFull, q.Put("test", 0, 600, []byte("three")))
+
+	db, err := sql.Open("sqlite3", file)
+	ok(t, err)
+	defer db.Close()
+	var mode string
+	ok(t, db.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	equals(t, "wal", mode)
+}
+
+func TestBuilderInvalid(t *testing.T) {
+	_, err := queue.Build(tempfile()).MaintenanceInterval(0).Create()
+	assert(t, err != nil, "expected error for zero maintenance interval")
+}
@@ -0,0 +1,73 @@
+package queue
+
+import (
+	"reflect"
+	"time"
+)
+
+// ChainedQueue checks several queues in order, such as a hot queue
+// followed by a cold one. Create one with Chain.
+type ChainedQueue struct {
+	queues []*Queue
+}
+
+// Chain returns a ChainedQueue over queues, highest precedence first
+func Chain(queues ...*Queue) *ChainedQueue {
+	return &ChainedQueue{queues: queues}
+}
+
+// Put puts a job in the first queue
+func (c *ChainedQueue) Put(tube string, priority int, ttr int, data []byte, opts ...PutOption) error {
+	return c.queues[0].Put(tube, priority, ttr, data, opts...)
+}
+
+// Reserve reserves a job from the first queue that has one ready in
+// tube. If none do, it waits up to timeout seconds for a job to be put in
+// any of the queues and checks them again. It returns nil if there is
+// still no job.
+func (c *ChainedQueue) Reserve(tube string, timeout int) (*Job, error) {
+	j, err := c.reserve(tube)
+	if err != nil || j != nil || timeout <= 0 {
+		return j, err
+	}
+
+	// wait on every queue's wait and exit channels and a timer
+	cases := make([]reflect.SelectCase, 0, 2*len(c.queues)+1)
+	for _, q := range c.queues {
+		cases = append(cases,
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(q.wait)},
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(q.exit)})
+	}
+	cases = append(cases, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(time.After(time.Second * time.Duration(timeout))),
+	})
+	if i, _, _ := reflect.Select(cases); i < len(cases)-1 && i%2 == 1 {
+		return nil, ErrClosed
+	}
+	return c.reserve(tube)
+}
+
+// reserve reserves from the first queue with a ready job without waiting
+func (c *ChainedQueue) reserve(tube string) (*Job, error) {
+	for _, q := range c.queues {
+		j, err := q.Reserve(tube, 0)
+		if err != nil || j != nil {
+			return j, err
+		}
+	}
+	return nil, nil
+}
+
+// Jobs returns the jobs in tube in all the queues, in chain order
+func (c *ChainedQueue) Jobs(tube string) ([]*Job, error) {
+	jobs := make([]*Job, 0)
+	for _, q := range c.queues {
+		j, err := q.Jobs(tube)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j...)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,53 @@
+package queue_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestChain(t *testing.T) {
+	queues := make([]*queue.Queue, 3)
+	for i := range queues {
+		file := tempfile()
+		q, err := queue.New(file, 4, 3)
+		ok(t, err)
+		defer os.Remove(file)
+		defer q.Close()
+		queues[i] = q
+	}
+	c := queue.Chain(queues...)
+
+	ok(t, queues[2].Put("test", 0, 600, []byte("cold")))
+	ok(t, queues[1].Put("test", 0, 600, []byte("warm")))
+
+	jobs, err := c.Jobs("test")
+	ok(t, err)
+	equals(t, 2, len(jobs))
+	equals(t, []byte("warm"), jobs[0].Data)
+	equals(t, []byte("cold"), jobs[1].Data)
+
+	j, err := c.Reserve("test", 0)
+	ok(t, err)
+	assert(t, j != nil, "job is nil")
+	equals(t, []byte("warm"), j.Data)
+
+	j, err = c.Reserve("test", 0)
+	ok(t, err)
+	assert(t, j != nil, "job is nil")
+	equals(t, []byte("cold"), j.Data)
+
+	j, err = c.Reserve("test", 0)
+	ok(t, err)
+	assert(t, j == nil, "job is not nil")
+
+	ok(t, c.Put("test", 0, 600, []byte("hot")))
+	j, err = c.Reserve("test", 1)
+	ok(t, err)
+	assert(t, j != nil, "job is nil")
+	equals(t, []byte("hot"), j.Data)
+	jobs, err = queues[0].Jobs("test")
+	ok(t, err)
+	equals(t, 1, len(jobs))
+}
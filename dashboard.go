@@ -0,0 +1,167 @@
+package queue
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// dashboardBarWidth is the number of characters in the longest bar
+const dashboardBarWidth = 40
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>Queue {{.Filename}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.bar { font-family: monospace; color: #36c; }
+</style>
+</head>
+<body>
+<h1>Queue {{.Filename}}</h1>
+<p>Last maintenance: {{if .LastMaintenance.IsZero}}never{{else}}{{.LastMaintenance.Format "2006-01-02 15:04:05 MST"}}{{end}}</p>
+
+<h2>Overall</h2>
+<table>
+<tr><th>Tubes</th><th>Ready</th><th>Reserved</th><th>Delayed</th><th>Jobs expired</th></tr>
+<tr><td>{{len .Tubes}}</td><td>{{.Total.Ready}}</td><td>{{.Total.Reserved}}</td><td>{{.Total.Delayed}}</td><td>{{.Metrics.JobsExpired}}</td></tr>
+</table>
+
+<h2>Tubes</h2>
+<table>
+<tr><th>Tube</th><th>Ready</th><th>Reserved</th><th>Delayed</th><th>Oldest ready</th><th></th></tr>
+{{range .Tubes}}<tr><td>{{.Tube}}</td><td>{{.Ready}}</td><td>{{.Reserved}}</td><td>{{.Delayed}}</td><td>{{.MaxReadyAge}}</td><td class="bar">{{.Bar}}</td></tr>
+{{end}}</table>
+
+<h2>Top tubes by ready jobs</h2>
+<table>
+<tr><th>Tube</th><th>Ready</th></tr>
+{{range .Top}}<tr><td>{{.Tube}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+
+<h2>Recent jobs</h2>
+<table>
+<tr><th>Tube</th><th>ID</th><th>State</th><th>Priority</th><th>Created</th></tr>
+{{range .Recent}}<tr><td>{{.Tube}}</td><td>{{.ID}}</td><td>{{.State}}</td><td>{{.Priority}}</td><td>{{.Created.Format "2006-01-02 15:04:05.000"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// dashboardTube is a row of the tubes table
+type dashboardTube struct {
+	TubeStats
+	Bar string
+}
+
+// dashboardRecent is a row of the recent jobs table
+type dashboardRecent struct {
+	Tube     string
+	ID       int
+	State    string
+	Priority uint
+	Created  time.Time
+}
+
+var stateNames = map[int]string{
+	STATE_READY:    "ready",
+	STATE_RESERVED: "reserved",
+	STATE_DELAYED:  "delayed",
+	STATE_BURIED:   "buried",
+}
+
+// HealthDashboard is an http.HandlerFunc serving an HTML page describing
+// the queue. The page refreshes itself every 30 seconds.
+func (q *Queue) HealthDashboard(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Filename        string
+		LastMaintenance time.Time
+		Metrics         Metrics
+		Total           TubeStats
+		Tubes           []dashboardTube
+		Top             []TubeStatEntry
+		Recent          []dashboardRecent
+	}
+	data.Filename = q.filename
+	data.Metrics = q.Metrics()
+	if ns := atomic.LoadInt64(&q.lastMaintenance); ns > 0 {
+		data.LastMaintenance = time.Unix(0, ns)
+	}
+
+	var most int64
+	err := q.ForeachTube(func(tube string, stats TubeStats) error {
+		data.Total.Ready += stats.Ready
+		data.Total.Reserved += stats.Reserved
+		data.Total.Delayed += stats.Delayed
+		if stats.Ready > most {
+			most = stats.Ready
+		}
+		data.Tubes = append(data.Tubes, dashboardTube{TubeStats: stats})
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range data.Tubes {
+		data.Tubes[i].Bar = bar(data.Tubes[i].Ready, most)
+	}
+
+	if data.Top, err = q.TopTubesBySize(10, STATE_READY); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recent, err := q.recentPerTube(5)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, j := range recent {
+		data.Recent = append(data.Recent, dashboardRecent{
+			Tube:     j.Tube,
+			ID:       j.ID,
+			State:    stateNames[j.State],
+			Priority: j.Priority,
+			Created:  j.Created,
+		})
+	}
+
+	// render before writing anything so a failure can still be reported
+	var buf bytes.Buffer
+	if err := dashboardTemplate.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// bar draws n relative to max with unicode block characters
+func bar(n, max int64) string {
+	if n <= 0 || max <= 0 {
+		return ""
+	}
+	eighths := int(n * dashboardBarWidth * 8 / max)
+	if eighths == 0 {
+		eighths = 1
+	}
+	partial := []string{"", "▏", "▎", "▍", "▌", "▋", "▊", "▉"}
+	return strings.Repeat("█", eighths/8) + partial[eighths%8]
+}
+
+// recentPerTube returns the n most recently created jobs in each tube
+func (q *Queue) recentPerTube(n int) ([]*Job, error) {
+	return q.queryJobs(`SELECT `+jobColumns+` FROM (
+                 SELECT *, ROW_NUMBER() OVER (PARTITION BY tube ORDER BY created DESC, seq DESC) AS rank
+                 FROM simple_queue
+               ) WHERE rank <= ? ORDER BY tube, created DESC, seq DESC`, n)
+}
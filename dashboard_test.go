@@ -0,0 +1,37 @@
+package queue_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestHealthDashboard(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 7; i++ {
+			ok(t, q.Put("emails", 0, 600, []byte("testing")))
+		}
+		ok(t, q.Put("<reports>", 0, 600, []byte("testing")))
+		_, err := q.Reserve("emails", 0)
+		ok(t, err)
+		ok(t, q.Maintanence())
+
+		w := httptest.NewRecorder()
+		q.HealthDashboard(w, httptest.NewRequest("GET", "/", nil))
+
+		equals(t, 200, w.Code)
+		assert(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/html"), "content type is %q", w.Header().Get("Content-Type"))
+		body := w.Body.String()
+		for _, want := range []string{
+			`<meta http-equiv="refresh" content="30">`,
+			"<td>emails</td><td>6</td><td>1</td>",
+			"<td>&lt;reports&gt;</td><td>1</td>",
+			"█",
+		} {
+			assert(t, strings.Contains(body, want), "body does not contain %q", want)
+		}
+		assert(t, !strings.Contains(body, "never"), "maintenance time not shown")
+	})
+}
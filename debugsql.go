@@ -0,0 +1,228 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverKey identifies the wrapping of a driver registered by
+// registerDriver
+type driverKey struct {
+	debugSQL io.Writer
+	timeout  time.Duration
+}
+
+// wrappedDrivers are the names of the drivers registered by
+// registerDriver. Registered drivers can't be removed, so each wrapping is
+// only registered once.
+var wrappedDrivers = struct {
+	sync.Mutex
+	names map[driverKey]string
+	n     int
+}{names: make(map[driverKey]string)}
+
+// registerDriver registers the sqlite3 driver wrapped as the options
+// require, unless it already is, and returns its name
+func registerDriver(o Options) string {
+	key := driverKey{debugSQL: o.DebugSQL, timeout: o.DefaultTimeout}
+	// a writer that can't be a map key gets a driver of its own
+	reusable := o.DebugSQL == nil || reflect.TypeOf(o.DebugSQL).Comparable()
+
+	wrappedDrivers.Lock()
+	defer wrappedDrivers.Unlock()
+	if reusable {
+		if name, ok := wrappedDrivers.names[key]; ok {
+			return name
+		}
+	}
+
+	var d driver.Driver = &sqlite3.SQLiteDriver{}
+	if o.DebugSQL != nil {
+		d = &debugDriver{Driver: d, log: &sqlLogger{w: o.DebugSQL}}
+	}
+	if o.DefaultTimeout > 0 {
+		d = &timeoutDriver{Driver: d, timeout: o.DefaultTimeout}
+	}
+	wrappedDrivers.n++
+	name := fmt.Sprintf("sqlite3-wrapped-%d", wrappedDrivers.n)
+	sql.Register(name, d)
+	if reusable {
+		wrappedDrivers.names[key] = name
+	}
+	return name
+}
+
+// sqlLogger writes one line per statement
+type sqlLogger struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (l *sqlLogger) log(query string, args []driver.NamedValue, rows int64, start time.Time) {
+	d := time.Since(start)
+	values := make([]string, len(args))
+	for i, a := range args {
+		if b, ok := a.Value.([]byte); ok {
+			values[i] = fmt.Sprintf("%q", b)
+		} else {
+			values[i] = fmt.Sprint(a.Value)
+		}
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	fmt.Fprintf(l.w, "[%s] query=%q args=[%s] rows=%d duration=%.3fms\n",
+		start.UTC().Format(time.RFC3339), query, strings.Join(values, " "), rows,
+		float64(d)/float64(time.Millisecond))
+}
+
+type debugDriver struct {
+	driver.Driver
+	log *sqlLogger
+}
+
+func (d *debugDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &debugConn{Conn: c, log: d.log}, nil
+}
+
+// debugConn logs statements executed on a connection
+type debugConn struct {
+	driver.Conn
+	log *sqlLogger
+}
+
+func (c *debugConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *debugConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var s driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = p.PrepareContext(ctx, query)
+	} else {
+		s, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &debugStmt{Stmt: s, query: query, log: c.log}, nil
+}
+
+func (c *debugConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *debugConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := e.ExecContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	n, _ := res.RowsAffected()
+	c.log.log(query, args, n, start)
+	return res, nil
+}
+
+func (c *debugConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &debugRows{Rows: rows, query: query, args: args, start: start, log: c.log}, nil
+}
+
+// debugStmt logs executions of a prepared statement
+type debugStmt struct {
+	driver.Stmt
+	query string
+	log   *sqlLogger
+}
+
+func (s *debugStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var res driver.Result
+	var err error
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		res, err = s.Stmt.Exec(values(args))
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, _ := res.RowsAffected()
+	s.log.log(s.query, args, n, start)
+	return res, nil
+}
+
+func (s *debugStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(values(args))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &debugRows{Rows: rows, query: s.query, args: args, start: start, log: s.log}, nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	v := make([]driver.Value, len(args))
+	for i, a := range args {
+		v[i] = a.Value
+	}
+	return v
+}
+
+// debugRows counts the rows read and logs the query when closed
+type debugRows struct {
+	driver.Rows
+	query string
+	args  []driver.NamedValue
+	start time.Time
+	n     int64
+	log   *sqlLogger
+}
+
+func (r *debugRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == nil {
+		r.n++
+	}
+	return err
+}
+
+func (r *debugRows) Close() error {
+	r.log.log(r.query, r.args, r.n, r.start)
+	return r.Rows.Close()
+}
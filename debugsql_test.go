@@ -0,0 +1,80 @@
+package queue_test
+
+import (
+	"bytes"
+	"database/sql"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use
+type lockedBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+// Take returns and clears the contents of the buffer
+func (b *lockedBuffer) Take() string {
+	b.Lock()
+	defer b.Unlock()
+	s := b.buf.String()
+	b.buf.Reset()
+	return s
+}
+
+func TestDebugSQL(t *testing.T) {
+	var log lockedBuffer
+	line := regexp.MustCompile(`^\[\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ\] query=".*" args=\[.*\] rows=\d+ duration=\d+\.\d+ms$`)
+	find := func(lines []string, prefix string, rows string) bool {
+		for _, l := range lines {
+			if strings.Contains(l, `query="`+prefix) && strings.Contains(l, " rows="+rows+" ") {
+				return true
+			}
+		}
+		return false
+	}
+
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		log.Take()
+
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		lines := strings.Split(strings.TrimSpace(log.Take()), "\n")
+		for _, l := range lines {
+			assert(t, line.MatchString(l), "unexpected log line: %s", l)
+		}
+		assert(t, find(lines, "INSERT into simple_queue", "1"), "no INSERT logged: %v", lines)
+		assert(t, strings.Contains(strings.Join(lines, "\n"), `"testing"`), "data not logged: %v", lines)
+
+		_, err := q.Reserve("test", 0)
+		ok(t, err)
+		lines = strings.Split(strings.TrimSpace(log.Take()), "\n")
+		assert(t, find(lines, "SELECT", "1"), "no SELECT logged: %v", lines)
+		assert(t, find(lines, "UPDATE simple_queue", "1"), "no UPDATE logged: %v", lines)
+	}, queue.WithDebugSQL(&log))
+}
+
+func TestDebugSQLDriverReused(t *testing.T) {
+	var log lockedBuffer
+	open := func() {
+		withQ(t, func(q *queue.Queue, t *testing.T) {
+			ok(t, q.Put("test", 0, 600, []byte("testing")))
+		}, queue.WithDebugSQL(&log), queue.WithDefaultTimeout(time.Minute))
+	}
+
+	// reopening with the same options doesn't register another driver
+	open()
+	drivers := len(sql.Drivers())
+	open()
+	equals(t, drivers, len(sql.Drivers()))
+}
@@ -0,0 +1,117 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// jobEncodingVersion is the first byte of the output of MarshalBinary
+const jobEncodingVersion = 1
+
+// jobGob holds the fields of a Job encoded by MarshalBinary. Encoding Job
+// itself would recurse into MarshalBinary.
+type jobGob struct {
+	ID           int
+	Tube         string
+	Created      time.Time
+	Modified     time.Time
+	State        int
+	Priority     uint
+	Data         []byte
+	TTR          time.Duration
+	TTL          time.Duration
+	ReserveCount int
+	Seq          int64
+	Worker       string
+	ErrorInfo    string
+	Latency      time.Duration
+	Attempts     int
+	// PriorityFloat is missing from encodings made before it was added
+	PriorityFloat float64
+	Metadata      map[string]string
+	TraceID       string
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (j *Job) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(jobEncodingVersion)
+	err := gob.NewEncoder(&buf).Encode(jobGob{
+		ID:            j.ID,
+		Tube:          j.Tube,
+		Created:       j.Created,
+		Modified:      j.Modified,
+		State:         j.State,
+		Priority:      j.Priority,
+		Data:          j.Data,
+		TTR:           j.TTR,
+		TTL:           j.TTL,
+		ReserveCount:  j.ReserveCount,
+		Seq:           j.Seq,
+		Worker:        j.Worker,
+		ErrorInfo:     j.ErrorInfo,
+		Latency:       j.Latency,
+		Attempts:      j.Attempts,
+		PriorityFloat: j.PriorityFloat,
+		Metadata:      j.Metadata,
+		TraceID:       j.TraceID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. The job is not
+// attached to a queue, so it must be passed to AttachQueue before it can
+// be deleted, released or touched.
+func (j *Job) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty job encoding")
+	}
+	if data[0] != jobEncodingVersion {
+		return fmt.Errorf("unsupported job encoding version %d", data[0])
+	}
+
+	var g jobGob
+	if err := gob.NewDecoder(bytes.NewReader(data[1:])).Decode(&g); err != nil {
+		return err
+	}
+	*j = Job{
+		ID:            g.ID,
+		Tube:          g.Tube,
+		Created:       g.Created,
+		Modified:      g.Modified,
+		State:         g.State,
+		Priority:      g.Priority,
+		Data:          g.Data,
+		TTR:           g.TTR,
+		TTL:           g.TTL,
+		ReserveCount:  g.ReserveCount,
+		Seq:           g.Seq,
+		Worker:        g.Worker,
+		ErrorInfo:     g.ErrorInfo,
+		Latency:       g.Latency,
+		Attempts:      g.Attempts,
+		PriorityFloat: g.PriorityFloat,
+		Metadata:      g.Metadata,
+		TraceID:       g.TraceID,
+	}
+	if j.PriorityFloat == 0 {
+		j.PriorityFloat = float64(j.Priority)
+	}
+	return nil
+}
+
+// AttachQueue sets the queue the job belongs to, such as after
+// UnmarshalBinary
+func (j *Job) AttachQueue(q *Queue) error {
+	if q == nil {
+		return errors.New("queue is nil")
+	}
+	j.q = q
+	return nil
+}
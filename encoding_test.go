@@ -0,0 +1,39 @@
+package queue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestJobBinary(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 7, 600, []byte("testing"), queue.WithTTL(time.Hour)))
+		clock.Advance(time.Second)
+		j, err := q.ReserveAs("test", "worker-1", 0)
+		ok(t, err)
+		j.ErrorInfo = "info"
+
+		data, err := j.MarshalBinary()
+		ok(t, err)
+
+		var got queue.Job
+		ok(t, got.UnmarshalBinary(data))
+		exp := *j
+		ok(t, exp.AttachQueue(q))
+		ok(t, got.AttachQueue(q))
+		equals(t, exp, got)
+
+		// the attached job can be used
+		ok(t, got.Delete())
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 0, len(jobs))
+
+		err = got.UnmarshalBinary(append([]byte{99}, data[1:]...))
+		assert(t, err != nil, "expected error for unknown version")
+		assert(t, got.AttachQueue(nil) != nil, "expected error for nil queue")
+	}, queue.WithClock(clock.Now))
+}
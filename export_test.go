@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// CachedStatements returns the number of statements cached by q
+func CachedStatements(q *Queue) int {
+	q.stmtLock.Lock()
+	defer q.stmtLock.Unlock()
+	return len(q.stmts)
+}
+
+// DisableStatementCache stops q caching statements
+func DisableStatementCache(q *Queue) {
+	q.closeStmts()
+}
+
+// LastMaintenance returns when q last ran maintenance, or the zero time
+func LastMaintenance(q *Queue) time.Time {
+	if ns := atomic.LoadInt64(&q.lastMaintenance); ns > 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// MigrateMillis runs the migration converting seconds to milliseconds
+func MigrateMillis(tx *sql.Tx) error {
+	return migrateMillis(tx)
+}
+
+// AcquireLease takes or renews the maintenance lease for q
+func AcquireLease(q *Queue, interval time.Duration) (bool, error) {
+	return q.acquireLease(interval)
+}
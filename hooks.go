@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"database/sql"
+	"time"
+)
+
+// job event names passed to hooks
+const (
+	eventPut     = "put"
+	eventReserve = "reserve"
+	eventDelete  = "delete"
+	eventBury    = "bury"
+	eventExpire  = "expire"
+)
+
+// jobEvent describes a change to a job
+type jobEvent struct {
+	Event string
+	JobID int
+	Tube  string
+	Time  time.Time
+}
+
+// hook is called after a change to a job is committed. It must not block.
+type hook func(e jobEvent)
+
+// addHook registers h and returns a function that removes it
+func (q *Queue) addHook(h hook) func() {
+	q.hookLock.Lock()
+	defer q.hookLock.Unlock()
+	if q.hooks == nil {
+		q.hooks = make(map[int]hook)
+	}
+	q.nextHook++
+	id := q.nextHook
+	q.hooks[id] = h
+
+	return func() {
+		q.hookLock.Lock()
+		defer q.hookLock.Unlock()
+		delete(q.hooks, id)
+	}
+}
+
+// hasHooks returns true if any hooks or observers are registered. It is
+// used to skip work only needed to emit events.
+func (q *Queue) hasHooks() bool {
+	q.hookLock.Lock()
+	n := len(q.hooks)
+	q.hookLock.Unlock()
+	q.observerLock.RLock()
+	defer q.observerLock.RUnlock()
+	return n > 0 || len(q.observers) > 0
+}
+
+// emit calls the registered hooks and observers with an event for j
+func (q *Queue) emit(event string, j *Job) {
+	now := q.now()
+	q.rates.record(event, j.Tube, now)
+	q.observe(event, j)
+
+	q.hookLock.Lock()
+	hooks := make([]hook, 0, len(q.hooks))
+	for _, h := range q.hooks {
+		hooks = append(hooks, h)
+	}
+	q.hookLock.Unlock()
+
+	if len(hooks) == 0 {
+		return
+	}
+	e := jobEvent{Event: event, JobID: j.ID, Tube: j.Tube, Time: now}
+	for _, h := range hooks {
+		h(e)
+	}
+}
+
+// audit records event for jobs in the audit table if Audit is set. It is
+// called in the transaction making the change, so the change is only
+// committed along with its audit rows.
+func (q *Queue) audit(tx *sql.Tx, event string, jobs ...*Job) error {
+	if !q.options.Audit {
+		return nil
+	}
+	now := toMillis(q.now())
+	for _, j := range jobs {
+		_, err := tx.Exec("INSERT into simple_queue_audit (job_id, tube, event, created) VALUES(?, ?, ?, ?)",
+			j.ID, j.Tube, event, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
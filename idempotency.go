@@ -0,0 +1,50 @@
+package queue
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// errDuplicatePut is returned by insert when a job with the same
+// idempotency key was put within the window. Put ignores it.
+var errDuplicatePut = errors.New("duplicate put")
+
+// defaultIdempotencyCacheSize is the number of recent puts remembered for
+// Options.IdempotencyWindow if IdempotencyCacheSize is not set
+const defaultIdempotencyCacheSize = 1024
+
+// putKey identifies a put by its tube and data
+func putKey(tube string, data []byte) string {
+	h := sha256.New()
+	h.Write([]byte(tube))
+	h.Write([]byte{0})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recentPut returns the idempotency key for data put to tube and whether
+// the same put succeeded within the idempotency window. The key is empty
+// if there is no window.
+func (q *Queue) recentPut(tube string, data []byte) (string, bool) {
+	if q.recentPuts == nil {
+		return "", false
+	}
+	key := putKey(tube, data)
+	return key, q.putRecently(key)
+}
+
+// rememberPut records a successful put for recentPut
+func (q *Queue) rememberPut(key string) {
+	if q.recentPuts != nil {
+		q.recentPuts.Add(key, q.now())
+	}
+}
+
+// putRecently returns true if a put with key succeeded within the
+// idempotency window
+func (q *Queue) putRecently(key string) bool {
+	last, ok := q.recentPuts.Get(key)
+	return ok && q.now().Sub(last.(time.Time)) < q.options.IdempotencyWindow
+}
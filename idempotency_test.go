@@ -0,0 +1,66 @@
+package queue_test
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestIdempotencyWindow(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		clock.Advance(30 * time.Second)
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		// a different tube or different data is not a duplicate
+		ok(t, q.Put("other", 0, 600, []byte("testing")))
+		ok(t, q.Put("test", 0, 600, []byte("different")))
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 2, len(jobs))
+
+		clock.Advance(31 * time.Second)
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		jobs, err = q.Jobs("test")
+		ok(t, err)
+		equals(t, 3, len(jobs))
+	}, queue.WithClock(clock.Now), queue.WithIdempotencyWindow(time.Minute), queue.WithIdempotencyCacheSize(16))
+}
+
+func TestIdempotencyConcurrent(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+
+	// two handles each have their own cache, so only the unique index
+	// can catch the duplicates
+	var queues []*queue.Queue
+	for i := 0; i < 2; i++ {
+		q, err := queue.New(file, 4, 3, queue.WithIdempotencyWindow(time.Minute))
+		ok(t, err)
+		defer q.Close()
+		queues = append(queues, q)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(q *queue.Queue) {
+			defer wg.Done()
+			errs <- q.Put("test", 0, 600, []byte("testing"))
+		}(queues[i%2])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		ok(t, err)
+	}
+
+	jobs, err := queues[0].Jobs("test")
+	ok(t, err)
+	equals(t, 1, len(jobs))
+}
@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IndexInfo describes an index in the queue database
+type IndexInfo struct {
+	Name  string
+	Table string
+	// RowCount is the number of rows recorded for the index by the
+	// last ANALYZE. It is zero if statistics have not been gathered.
+	RowCount int64
+}
+
+// ListIndexes returns all indexes in the queue database
+func (q *Queue) ListIndexes() ([]IndexInfo, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var stats int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='sqlite_stat1'").Scan(&stats); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT name, tbl_name, '' FROM sqlite_master WHERE type='index' ORDER BY name"
+	if stats > 0 {
+		query = `SELECT m.name, m.tbl_name, COALESCE(s.stat, '') FROM sqlite_master m
+                 LEFT JOIN sqlite_stat1 s ON s.idx = m.name
+                 WHERE m.type='index' ORDER BY m.name`
+	}
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := make([]IndexInfo, 0)
+	for rows.Next() {
+		var i IndexInfo
+		var stat string
+		if err := rows.Scan(&i.Name, &i.Table, &stat); err != nil {
+			return nil, err
+		}
+		// the first field of stat is the number of rows in the index
+		if fields := strings.Fields(stat); len(fields) > 0 {
+			i.RowCount, _ = strconv.ParseInt(fields[0], 10, 64)
+		}
+		indexes = append(indexes, i)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return indexes, tx.Commit()
+}
+
+// RebuildIndex runs REINDEX on the named index
+func (q *Queue) RebuildIndex(name string) error {
+	if err := q.writable(); err != nil {
+		return err
+	}
+	tx, err := q.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// index names cannot be bound as parameters, so only allow known indexes
+	var found string
+	err = tx.QueryRow("SELECT name FROM sqlite_master WHERE type='index' AND name=?", name).Scan(&found)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no such index: %s", name)
+		}
+		return err
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`REINDEX "%s"`, strings.Replace(found, `"`, `""`, -1))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AnalyzeIndexes updates the query planner statistics for the queue table
+func (q *Queue) AnalyzeIndexes() error {
+	if err := q.writable(); err != nil {
+		return err
+	}
+	_, err := q.db.Exec("ANALYZE simple_queue")
+	return err
+}
+
+// ExplainReserve returns the query plan SQLite uses to choose the job
+// Reserve takes from tube, one step per line
+func (q *Queue) ExplainReserve(tube string) (string, error) {
+	query, args := q.reserveQuery(q.tubeName(tube), 1, 0)
+	rows, err := q.db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			return "", err
+		}
+		plan = append(plan, detail)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(plan, "\n"), nil
+}
+
+// partialIndexName returns the name of the index created by
+// CreatePartialIndex for tube. The tube is hex encoded so any name is safe.
+func partialIndexName(tube string) string {
+	return "simple_queue_partial_" + hex.EncodeToString([]byte(tube))
+}
+
+// CreatePartialIndex creates an index covering only the ready jobs in
+// tube, in reserve order. This speeds up Reserve on a busy tube in a large
+// queue. It does nothing if the index exists.
+func (q *Queue) CreatePartialIndex(tube string) error {
+	if err := q.writable(); err != nil {
+		return err
+	}
+	tube = q.tubeName(tube)
+	// the WHERE clause of a partial index cannot use parameters
+	_, err := q.db.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s" ON simple_queue(priority DESC, created, seq, id)
+                                     WHERE tube='%s' AND state=%d`,
+		partialIndexName(tube), strings.Replace(tube, "'", "''", -1), STATE_READY))
+	return err
+}
@@ -0,0 +1,103 @@
+package queue_test
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestListIndexes(t *testing.T) {
+	withDB(t, func(q *queue.Queue, db *sql.DB, t *testing.T) {
+		for i := 0; i < 3; i++ {
+			ok(t, q.Put("test", i, 600, []byte("testing")))
+		}
+
+		_, err := db.Exec("DROP INDEX simple_queue_tube_idx")
+		ok(t, err)
+		_, err = db.Exec("CREATE INDEX simple_queue_tube_idx ON simple_queue(tube)")
+		ok(t, err)
+
+		ok(t, q.RebuildIndex("simple_queue_tube_idx"))
+		ok(t, q.AnalyzeIndexes())
+
+		indexes, err := q.ListIndexes()
+		ok(t, err)
+		var found *queue.IndexInfo
+		for i := range indexes {
+			if indexes[i].Name == "simple_queue_tube_idx" {
+				found = &indexes[i]
+			}
+		}
+		assert(t, found != nil, "index not listed")
+		equals(t, "simple_queue", found.Table)
+		equals(t, int64(3), found.RowCount)
+
+		rows, err := db.Query("EXPLAIN QUERY PLAN SELECT id from simple_queue WHERE tube=? AND state=? ORDER BY priority DESC, created ASC LIMIT 1", "test", queue.STATE_READY)
+		ok(t, err)
+		defer rows.Close()
+		var plan []string
+		for rows.Next() {
+			var id, parent, notused int
+			var detail string
+			ok(t, rows.Scan(&id, &parent, &notused, &detail))
+			plan = append(plan, detail)
+		}
+		ok(t, rows.Err())
+		// the composite reserve index is preferred over the tube index
+		assert(t, strings.Contains(strings.Join(plan, "\n"), "simple_queue_reserve_idx"), "index not used: %v", plan)
+	})
+}
+
+func TestRebuildIndexUnknown(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		err := q.RebuildIndex("nope")
+		assert(t, err != nil, "expected error for unknown index")
+	})
+}
+
+func TestTubeDrop(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("it's", 0, 600, []byte("testing")))
+		ok(t, q.Put("other", 0, 600, []byte("testing")))
+		ok(t, q.CreatePartialIndex("it's"))
+		ok(t, q.CreatePartialIndex("it's"))
+
+		partial := func() int {
+			indexes, err := q.ListIndexes()
+			ok(t, err)
+			n := 0
+			for _, i := range indexes {
+				if strings.HasPrefix(i.Name, "simple_queue_partial_") {
+					n++
+				}
+			}
+			return n
+		}
+		equals(t, 1, partial())
+
+		tube, err := q.Tube("it's")
+		ok(t, err)
+		ok(t, tube.Drop())
+		equals(t, 0, partial())
+
+		jobs, err := q.Jobs("it's")
+		ok(t, err)
+		equals(t, 0, len(jobs))
+		jobs, err = q.Jobs("other")
+		ok(t, err)
+		equals(t, 1, len(jobs))
+	})
+}
+
+func TestExplainReserve(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+		plan, err := q.ExplainReserve("test")
+		ok(t, err)
+		assert(t, strings.Contains(plan, "simple_queue_reserve_idx"), "index not used: %s", plan)
+		assert(t, !strings.Contains(plan, "TEMP B-TREE"), "reserve sorts: %s", plan)
+	})
+}
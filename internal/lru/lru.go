@@ -0,0 +1,80 @@
+// Package lru provides a simple least recently used cache.
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache is a fixed size LRU cache. It is safe for concurrent use.
+type Cache struct {
+	sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type entry struct {
+	key   string
+	value interface{}
+}
+
+// New returns a Cache holding at most size items
+func New(size int) *Cache {
+	return &Cache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Add sets the value for key, evicting the least recently used item if
+// the cache is full
+func (c *Cache) Add(key string, value interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		e.Value.(*entry).value = value
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&entry{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).key)
+	}
+}
+
+// Get returns the value for key
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*entry).value, true
+}
+
+// Remove deletes key from the cache
+func (c *Cache) Remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.order.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// Len returns the number of items in the cache
+func (c *Cache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,27 @@
+package lru
+
+import "testing"
+
+func TestCache(t *testing.T) {
+	c := New(2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+
+	// a is now most recently used, so b is evicted
+	if v, ok := c.Get("a"); !ok || v.(int) != 1 {
+		t.Fatalf("unexpected value for a: %v %v", v, ok)
+	}
+	c.Add("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b was not evicted")
+	}
+	if c.Len() != 2 {
+		t.Fatalf("unexpected length: %d", c.Len())
+	}
+
+	c.Remove("a")
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a was not removed")
+	}
+}
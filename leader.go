@@ -0,0 +1,63 @@
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// leaseIntervals is how many maintenance intervals the maintenance lease
+// lasts without being renewed, so another queue takes over if the holder
+// stops
+const leaseIntervals = 3
+
+// acquireLease takes or renews the maintenance lease and returns true if
+// this queue holds it. The lease is a single row naming its owner, so only
+// one of the queues open on a file runs maintenance at a time. The holder
+// only renews the lease once half of it has passed, and the others only
+// read it until it expires, so most intervals write nothing.
+func (q *Queue) acquireLease(interval time.Duration) (bool, error) {
+	now := toMillis(q.now())
+	lease := toDurationMillis(leaseIntervals * interval)
+	if now < q.leaseExpires-lease/2 {
+		return true, nil
+	}
+
+	var owner string
+	var expires int64
+	if err := q.db.QueryRow("SELECT owner, expires FROM simple_queue_leader WHERE id=1").Scan(&owner, &expires); err != nil {
+		return false, err
+	}
+	if owner != q.leaseOwner && expires >= now {
+		return false, nil
+	}
+
+	res, err := q.db.Exec("UPDATE simple_queue_leader SET owner=?, expires=? WHERE id=1 AND (owner=? OR expires < ?)",
+		q.leaseOwner, now+lease, q.leaseOwner, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	q.leaseExpires = now + lease
+	return true, nil
+}
+
+// releaseLease gives up the maintenance lease, if held, so another queue
+// can take it without waiting for it to expire
+func (q *Queue) releaseLease() error {
+	_, err := q.db.Exec("UPDATE simple_queue_leader SET expires=0 WHERE id=1 AND owner=?", q.leaseOwner)
+	return err
+}
+
+// randomID returns a random hex string
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
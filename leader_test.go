@@ -0,0 +1,78 @@
+package queue_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestMaintenanceLeader(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+
+	a, err := queue.New(file, 4, 1, queue.WithInstanceID("a"))
+	ok(t, err)
+	defer a.Close()
+	b, err := queue.New(file, 4, 1, queue.WithInstanceID("b"))
+	ok(t, err)
+	defer b.Close()
+
+	ok(t, a.Put("test", 0, 1, []byte("testing")))
+	j, err := b.Reserve("test", 0)
+	ok(t, err)
+	assert(t, j != nil, "job is nil")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := a.TubeStats("test")
+		ok(t, err)
+		if stats.Ready == 1 {
+			break
+		}
+		assert(t, time.Now().Before(deadline), "expired job was not reclaimed")
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	ranA := !queue.LastMaintenance(a).IsZero()
+	ranB := !queue.LastMaintenance(b).IsZero()
+	assert(t, ranA != ranB, "maintenance ran on a: %v, b: %v", ranA, ranB)
+}
+
+func TestLeaseSharedInstanceID(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+
+	a, err := queue.New(file, 4, 60, queue.WithInstanceID("same"))
+	ok(t, err)
+	defer a.Close()
+	b, err := queue.New(file, 4, 60, queue.WithInstanceID("same"))
+	ok(t, err)
+	defer b.Close()
+
+	leader, err := queue.AcquireLease(a, time.Minute)
+	ok(t, err)
+	equals(t, true, leader)
+	leader, err = queue.AcquireLease(b, time.Minute)
+	ok(t, err)
+	equals(t, false, leader)
+}
+
+func TestLeaseRenewal(t *testing.T) {
+	clock := newFakeClock()
+	var log lockedBuffer
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		log.Take()
+		for i := 0; i < 3; i++ {
+			leader, err := queue.AcquireLease(q, time.Second)
+			ok(t, err)
+			equals(t, true, leader)
+			clock.Advance(time.Second)
+		}
+		// taken at 0s and renewed at 2s, once half of the 3s lease had
+		// passed
+		equals(t, 2, strings.Count(log.Take(), "UPDATE simple_queue_leader"))
+	}, queue.WithClock(clock.Now), queue.WithDebugSQL(&log))
+}
@@ -0,0 +1,135 @@
+// Package middleware provides common PutMiddleware for simple-queue.
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/bakins/simple-queue"
+	"github.com/bakins/simple-queue/internal/lru"
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// dedupCacheSize is the number of recent puts remembered by
+// DeduplicateMiddleware
+const dedupCacheSize = 1024
+
+// BackoffPolicy returns how long to wait before the given retry attempt,
+// starting at 1.
+type BackoffPolicy func(attempt int) time.Duration
+
+// ConstantBackoff waits d between every attempt
+func ConstantBackoff(d time.Duration) BackoffPolicy {
+	return func(int) time.Duration {
+		return d
+	}
+}
+
+// ExponentialBackoff doubles the wait, starting at base, up to max
+func ExponentialBackoff(base, max time.Duration) BackoffPolicy {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// IsTransient reports whether err is an SQLite error that may succeed if
+// retried, such as a locked database.
+func IsTransient(err error) bool {
+	if e, ok := err.(sqlite3.Error); ok {
+		return e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// RetryMiddleware attempts a Put up to maxAttempts times while it fails
+// with a transient error, waiting between attempts according to backoff.
+func RetryMiddleware(maxAttempts int, backoff BackoffPolicy) queue.PutMiddleware {
+	return func(next queue.PutFunc) queue.PutFunc {
+		return func(ctx context.Context, tube string, priority int, ttr int, data []byte, opts ...queue.PutOption) error {
+			var err error
+			for attempt := 1; attempt <= maxAttempts; attempt++ {
+				if attempt > 1 {
+					select {
+					case <-time.After(backoff(attempt - 1)):
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				err = next(ctx, tube, priority, ttr, data, opts...)
+				if !IsTransient(err) {
+					return err
+				}
+			}
+			return err
+		}
+	}
+}
+
+// TimeoutMiddleware fails a Put that takes longer than d
+func TimeoutMiddleware(d time.Duration) queue.PutMiddleware {
+	return func(next queue.PutFunc) queue.PutFunc {
+		return func(ctx context.Context, tube string, priority int, ttr int, data []byte, opts ...queue.PutOption) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, tube, priority, ttr, data, opts...)
+		}
+	}
+}
+
+// DeduplicateMiddleware silently drops a Put of the same data to the same
+// tube as another Put within window. Only recent puts are remembered.
+func DeduplicateMiddleware(window time.Duration) queue.PutMiddleware {
+	seen := lru.New(dedupCacheSize)
+	return func(next queue.PutFunc) queue.PutFunc {
+		return func(ctx context.Context, tube string, priority int, ttr int, data []byte, opts ...queue.PutOption) error {
+			h := sha256.New()
+			h.Write([]byte(tube))
+			h.Write([]byte{0})
+			h.Write(data)
+			key := hex.EncodeToString(h.Sum(nil))
+
+			now := time.Now()
+			if last, ok := seen.Get(key); ok && now.Sub(last.(time.Time)) < window {
+				return nil
+			}
+			if err := next(ctx, tube, priority, ttr, data, opts...); err != nil {
+				return err
+			}
+			seen.Add(key, now)
+			return nil
+		}
+	}
+}
+
+// TracingMiddleware records a span for each Put
+func TracingMiddleware(tracer trace.Tracer) queue.PutMiddleware {
+	return func(next queue.PutFunc) queue.PutFunc {
+		return func(ctx context.Context, tube string, priority int, ttr int, data []byte, opts ...queue.PutOption) error {
+			ctx, span := tracer.Start(ctx, "queue.Put", trace.WithAttributes(
+				attribute.String("queue.tube", tube),
+				attribute.Int("queue.priority", priority),
+				attribute.Int("queue.data_size", len(data)),
+			))
+			defer span.End()
+
+			err := next(ctx, tube, priority, ttr, data, opts...)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+	"github.com/bakins/simple-queue/middleware"
+	"github.com/mattn/go-sqlite3"
+)
+
+// failing returns a PutFunc that fails with err the first failures calls
+// and counts calls
+func failing(failures int, err error, calls *int) queue.PutFunc {
+	return func(ctx context.Context, tube string, priority int, ttr int, data []byte, opts ...queue.PutOption) error {
+		*calls++
+		if *calls <= failures {
+			return err
+		}
+		return nil
+	}
+}
+
+func TestRetryMiddleware(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	retry := middleware.RetryMiddleware(3, middleware.ConstantBackoff(time.Millisecond))
+
+	var calls int
+	err := retry(failing(10, busy, &calls))(context.Background(), "test", 0, 600, nil)
+	if err != busy {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+
+	calls = 0
+	if err := retry(failing(1, busy, &calls))(context.Background(), "test", 0, 600, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 attempts, got %d", calls)
+	}
+
+	// other errors are not retried
+	calls = 0
+	other := errors.New("other")
+	if err := retry(failing(10, other, &calls))(context.Background(), "test", 0, 600, nil); err != other {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 attempt, got %d", calls)
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	slow := func(ctx context.Context, tube string, priority int, ttr int, data []byte, opts ...queue.PutOption) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	err := middleware.TimeoutMiddleware(10*time.Millisecond)(slow)(context.Background(), "test", 0, 600, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDeduplicateMiddleware(t *testing.T) {
+	var calls int
+	put := middleware.DeduplicateMiddleware(time.Minute)(failing(0, nil, &calls))
+
+	for i := 0; i < 3; i++ {
+		if err := put(context.Background(), "test", 0, 600, []byte("same")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := put(context.Background(), "other", 0, 600, []byte("same")); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 puts, got %d", calls)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := middleware.ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+	for attempt, exp := range []time.Duration{10, 20, 40, 50, 50} {
+		if d := b(attempt + 1); d != exp*time.Millisecond {
+			t.Fatalf("attempt %d: expected %s, got %s", attempt+1, exp*time.Millisecond, d)
+		}
+	}
+}
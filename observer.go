@@ -0,0 +1,63 @@
+package queue
+
+// Observer is notified of changes to jobs. Its methods are called
+// synchronously after each change is committed, so they should return
+// quickly. The Job passed is the job as changed. OnExpire is called both
+// when a ready job's TTL passes and it is deleted, and when a reserved
+// job's TTR passes and it is made ready again.
+type Observer interface {
+	OnPut(j *Job)
+	OnReserve(j *Job)
+	OnDelete(j *Job)
+	OnBury(j *Job)
+	OnExpire(j *Job)
+}
+
+// observer is a registered Observer. Registrations are compared by
+// pointer, as the Observer itself may not be comparable.
+type observer struct {
+	Observer
+}
+
+// AddObserver registers o and returns a function that unregisters it.
+// Observers are called in the order they were added.
+func (q *Queue) AddObserver(o Observer) func() {
+	r := &observer{o}
+	q.observerLock.Lock()
+	q.observers = append(q.observers, r)
+	q.observerLock.Unlock()
+
+	return func() {
+		q.observerLock.Lock()
+		defer q.observerLock.Unlock()
+		for i, x := range q.observers {
+			if x == r {
+				// copy, as observe may be ranging over the old slice
+				q.observers = append(q.observers[:i:i], q.observers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// observe calls the registered observers with an event for j
+func (q *Queue) observe(event string, j *Job) {
+	q.observerLock.RLock()
+	observers := q.observers
+	q.observerLock.RUnlock()
+
+	for _, o := range observers {
+		switch event {
+		case eventPut:
+			o.OnPut(j)
+		case eventReserve:
+			o.OnReserve(j)
+		case eventDelete:
+			o.OnDelete(j)
+		case eventBury:
+			o.OnBury(j)
+		case eventExpire:
+			o.OnExpire(j)
+		}
+	}
+}
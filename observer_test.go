@@ -0,0 +1,119 @@
+package queue_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+// countingObserver counts the calls of each Observer method
+type countingObserver struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+func (o *countingObserver) add(event string) {
+	o.Lock()
+	defer o.Unlock()
+	if o.counts == nil {
+		o.counts = make(map[string]int)
+	}
+	o.counts[event]++
+}
+
+func (o *countingObserver) Counts() map[string]int {
+	o.Lock()
+	defer o.Unlock()
+	counts := make(map[string]int, len(o.counts))
+	for k, v := range o.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+func (o *countingObserver) OnPut(j *queue.Job)     { o.add("put") }
+func (o *countingObserver) OnReserve(j *queue.Job) { o.add("reserve") }
+func (o *countingObserver) OnDelete(j *queue.Job)  { o.add("delete") }
+func (o *countingObserver) OnBury(j *queue.Job)    { o.add("bury") }
+func (o *countingObserver) OnExpire(j *queue.Job)  { o.add("expire") }
+
+func TestObserver(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		var o countingObserver
+		remove := q.AddObserver(&o)
+
+		ok(t, q.Put("test", 0, 600, []byte("deleted")))
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		ok(t, j.Delete())
+
+		ok(t, q.Put("test", 0, 600, []byte("buried")))
+		j, err = q.Reserve("test", 0)
+		ok(t, err)
+		ok(t, j.Bury("failed"))
+
+		ok(t, q.Put("test", 0, 600, []byte("expired"), queue.WithTTL(time.Second)))
+		clock.Advance(2 * time.Second)
+		ok(t, q.Maintanence())
+
+		// a reservation whose TTR passes also expires
+		ok(t, q.Put("test", 0, 1, []byte("reclaimed")))
+		_, err = q.Reserve("test", 0)
+		ok(t, err)
+		clock.Advance(2 * time.Second)
+		ok(t, q.Maintanence())
+
+		equals(t, map[string]int{"put": 4, "reserve": 3, "delete": 1, "bury": 1, "expire": 2}, o.Counts())
+
+		remove()
+		ok(t, q.Put("test", 0, 600, []byte("unobserved")))
+		equals(t, 4, o.Counts()["put"])
+	}, queue.WithClock(clock.Now))
+}
+
+// recordingObserver appends a line for each call to a shared log
+type recordingObserver struct {
+	name string
+	log  *[]string
+}
+
+func (o recordingObserver) record(event string, j *queue.Job) {
+	*o.log = append(*o.log, fmt.Sprintf("%s %s %s %d", o.name, event, j.Data, j.State))
+}
+
+func (o recordingObserver) OnPut(j *queue.Job)     { o.record("put", j) }
+func (o recordingObserver) OnReserve(j *queue.Job) { o.record("reserve", j) }
+func (o recordingObserver) OnDelete(j *queue.Job)  { o.record("delete", j) }
+func (o recordingObserver) OnBury(j *queue.Job)    { o.record("bury", j) }
+func (o recordingObserver) OnExpire(j *queue.Job)  { o.record("expire", j) }
+
+func TestObserverOrder(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		var log []string
+		q.AddObserver(recordingObserver{name: "first", log: &log})
+		remove := q.AddObserver(recordingObserver{name: "second", log: &log})
+		q.AddObserver(recordingObserver{name: "third", log: &log})
+
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		_, err := q.Reserve("test", 0)
+		ok(t, err)
+		remove()
+		ok(t, q.Put("test", 0, 600, []byte("again")))
+
+		// observers get the job itself, in the order they were added
+		equals(t, []string{
+			fmt.Sprintf("first put testing %d", queue.STATE_READY),
+			fmt.Sprintf("second put testing %d", queue.STATE_READY),
+			fmt.Sprintf("third put testing %d", queue.STATE_READY),
+			fmt.Sprintf("first reserve testing %d", queue.STATE_RESERVED),
+			fmt.Sprintf("second reserve testing %d", queue.STATE_RESERVED),
+			fmt.Sprintf("third reserve testing %d", queue.STATE_RESERVED),
+			fmt.Sprintf("first put again %d", queue.STATE_READY),
+			fmt.Sprintf("third put again %d", queue.STATE_READY),
+		}, log)
+	})
+}
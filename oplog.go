@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// opLogTubeSize is the fixed size of the tube field of an op log record
+const opLogTubeSize = 255
+
+// errTubeTooLong is returned for puts to tubes whose names do not fit in
+// an op log record
+var errTubeTooLong = errors.New("tube name too long for op log")
+
+// opLogHeader is the fixed width part of an op log record. It is followed
+// by DataLen bytes of data.
+type opLogHeader struct {
+	Timestamp int64
+	Priority  int64
+	TTR       int64
+	TubeLen   uint8
+	Tube      [opLogTubeSize]byte
+	DataLen   uint32
+}
+
+// opLog appends a record of each new job to a file
+type opLog struct {
+	sync.Mutex
+	f *os.File
+}
+
+func openOpLog(path string) (*opLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &opLog{f: f}, nil
+}
+
+// append writes a record for a job
+func (l *opLog) append(at time.Time, tube string, priority int, ttr time.Duration, data []byte) error {
+	if len(tube) > opLogTubeSize {
+		return errTubeTooLong
+	}
+	h := opLogHeader{
+		Timestamp: toMillis(at),
+		Priority:  int64(priority),
+		TTR:       toDurationMillis(ttr),
+		TubeLen:   uint8(len(tube)),
+		DataLen:   uint32(len(data)),
+	}
+	copy(h.Tube[:], tube)
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, h); err != nil {
+		return err
+	}
+	buf.Write(data)
+
+	// a single write keeps records whole
+	l.Lock()
+	defer l.Unlock()
+	_, err := l.f.Write(buf.Bytes())
+	return err
+}
+
+func (l *opLog) close() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.f.Close()
+}
+
+// ReplayLog puts the jobs recorded in the op log at path, in the order
+// they were logged, and returns how many were put. The jobs are put in a
+// single transaction and are not written to this queue's op log.
+func (q *Queue) ReplayLog(path string) (int64, error) {
+	if err := q.writable(); err != nil {
+		return 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var jobs []*Job
+	for {
+		var h opLogHeader
+		if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, err
+		}
+		data := make([]byte, h.DataLen)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return 0, err
+		}
+
+		j, err := q.insert(tx, string(h.Tube[:h.TubeLen]), int(h.Priority), 0, data,
+			putOptions{ttr: fromDurationMillis(h.TTR)})
+		if err != nil {
+			return 0, err
+		}
+		jobs = append(jobs, j)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, j := range jobs {
+		q.notify(j)
+	}
+	q.WakeAll()
+	return int64(len(jobs)), nil
+}
@@ -0,0 +1,83 @@
+package queue_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestReplayLog(t *testing.T) {
+	log := tempfile()
+	defer os.Remove(log)
+
+	file := tempfile()
+	q, err := queue.New(file, 4, 3, queue.WithOpLog(log))
+	ok(t, err)
+	for i := 0; i < 100; i++ {
+		ok(t, q.Put(fmt.Sprintf("tube%d", i%4), i%3, 600, []byte(fmt.Sprint(i))))
+	}
+	ok(t, q.Close())
+	ok(t, os.Remove(file))
+
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		n, err := q.ReplayLog(log)
+		ok(t, err)
+		equals(t, int64(100), n)
+
+		total := 0
+		for tube := 0; tube < 4; tube++ {
+			jobs, err := q.Jobs(fmt.Sprintf("tube%d", tube))
+			ok(t, err)
+			equals(t, 25, len(jobs))
+			total += len(jobs)
+			for _, j := range jobs {
+				var i int
+				_, err := fmt.Sscan(string(j.Data), &i)
+				ok(t, err)
+				equals(t, tube, i%4)
+				equals(t, uint(i%3), j.Priority)
+				equals(t, 600*time.Second, j.TTR)
+			}
+		}
+		equals(t, 100, total)
+	})
+}
+
+func TestOpLogEveryPut(t *testing.T) {
+	log := tempfile()
+	defer os.Remove(log)
+
+	file := tempfile()
+	clock := newFakeClock()
+	q, err := queue.New(file, 4, 3, queue.WithOpLog(log), queue.WithClock(clock.Now))
+	ok(t, err)
+	ok(t, q.Put("test", 0, 600, []byte("put")))
+	ok(t, q.PutUpsert("test", "key", 0, 600, []byte("upsert")))
+	_, err = q.PutMulti(context.Background(), []queue.TubeJobSpec{
+		{Tube: "test", TTR: 600, Data: []byte("multi 1")},
+		{Tube: "test", TTR: 600, Data: []byte("multi 2")},
+	})
+	ok(t, err)
+	ok(t, q.PutRecurring("test", "@every 1s", 0, 600, []byte("recurring")))
+	clock.Advance(time.Second)
+	ok(t, q.Maintanence())
+	ok(t, q.Close())
+	ok(t, os.Remove(file))
+
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		_, err := q.ReplayLog(log)
+		ok(t, err)
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		var data []string
+		for _, j := range jobs {
+			data = append(data, string(j.Data))
+		}
+		equals(t, []string{"put", "upsert", "multi 1", "multi 2", "recurring"}, data)
+	})
+}
@@ -0,0 +1,416 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+type (
+	// Options holds the configuration used when opening a Queue.
+	Options struct {
+		// Buffer is the size of the channel used to wake waiting reservers.
+		Buffer int
+		// MaintenanceInterval is how often expired jobs are handled.
+		MaintenanceInterval time.Duration
+		// Audit records each put, reserve, delete, bury and expiry in
+		// the simple_queue_audit table. See NewReaper for pruning it.
+		Audit bool
+		// InstanceID identifies the queue in the maintenance lease, so
+		// only one of the queues open on a file runs maintenance at a
+		// time. Each queue adds a random suffix, so queues sharing an ID
+		// still take turns. If empty, a random ID is used.
+		InstanceID string
+		// MaintenanceBatchSize is the most expired reservations
+		// maintenance reclaims in one transaction. Zero uses a default
+		// of 1000.
+		MaintenanceBatchSize int
+		// WALMode enables SQLite write-ahead logging.
+		WALMode bool
+		// BusyTimeout is how long SQLite waits on a locked database.
+		// Zero uses the driver default.
+		BusyTimeout time.Duration
+		// MaxCapacity is the maximum number of jobs in the queue. Zero
+		// means unlimited.
+		MaxCapacity int
+		// MaxDBSizeBytes is the maximum size of the database file. Put
+		// returns ErrQueueFull once it is reached. Zero means unlimited.
+		MaxDBSizeBytes int64
+		// MaxDataSize is the maximum size in bytes of a job's data. Zero
+		// means unlimited.
+		MaxDataSize int
+		// EncodeData, if set, transforms job data before it is stored.
+		EncodeData func([]byte) ([]byte, error)
+		// DecodeData, if set, transforms stored job data before it is
+		// returned. It should reverse EncodeData.
+		DecodeData func([]byte) ([]byte, error)
+		// TubeNameFunc, if set, maps the tube names given to every
+		// method taking a tube to the names stored, such as to prefix
+		// them with a tenant. Jobs and stats returned have the stored
+		// name.
+		TubeNameFunc func(string) string
+		// Selector chooses which job Reserve takes. If nil,
+		// DefaultSelector is used.
+		Selector Selector
+		// PutMiddleware wraps Put. The first middleware is the outermost.
+		PutMiddleware []PutMiddleware
+		// Clock returns the current time. Defaults to time.Now.
+		Clock func() time.Time
+		// MaxInFlightPerWorker is the most jobs a worker may hold
+		// reserved with ReserveAs. Zero means unlimited.
+		MaxInFlightPerWorker int
+		// ExpiryDeadLetterTube, if set, is the tube maintenance moves
+		// reserved jobs to when their TTR lapses, instead of making them
+		// ready again in their own tube.
+		ExpiryDeadLetterTube string
+		// BuriedRetention is how long buried jobs are kept before
+		// maintenance deletes them. Zero keeps them forever.
+		BuriedRetention time.Duration
+		// DebugSQL, if set, receives a line for every SQL statement run.
+		DebugSQL io.Writer
+		// Propagator, if set, is used by PutContext to store the trace
+		// context of a put in the job's metadata.
+		Propagator propagation.TextMapPropagator
+		// IdempotencyWindow, if set, is how long Put remembers a job so
+		// that putting the same data to the same tube again is ignored.
+		IdempotencyWindow time.Duration
+		// IdempotencyCacheSize is the number of recent puts remembered
+		// for IdempotencyWindow. Zero uses a default of 1024.
+		IdempotencyCacheSize int
+		// RateWindowSize is the number of events remembered per tube and
+		// operation for RateOf. Zero uses a default of 1024.
+		RateWindowSize int
+		// DefaultTimeout, if set, is the deadline for each statement run
+		// against the database. Zero means no deadline.
+		DefaultTimeout time.Duration
+		// OpLog, if set, is the path of a file to which every Put is
+		// appended. See WithOpLog.
+		OpLog string
+		// SharedCache opens the database in SQLite's shared cache mode so
+		// Queues in the same process share one cache.
+		SharedCache bool
+		// ReadOnly opens the database read only. Migrations and
+		// maintenance are not run and writes return ErrReadOnly.
+		ReadOnly bool
+	}
+
+	// Option modifies the Options used by New.
+	Option func(*Options)
+
+	// PutFunc puts a job. It is the signature of Queue.Put with a context.
+	PutFunc func(ctx context.Context, tube string, priority int, ttr int, data []byte, opts ...PutOption) error
+
+	// PutMiddleware wraps a PutFunc to add behavior to Put.
+	PutMiddleware func(next PutFunc) PutFunc
+
+	// PutOption modifies a single Put.
+	PutOption func(*putOptions)
+
+	putOptions struct {
+		ttr       time.Duration
+		ttl       time.Duration
+		dedupKey  string
+		dependsOn int
+		delay     time.Duration
+		score     *float64
+		metadata  map[string]string
+		traceID   string
+		// putKey is the idempotency key, set by put
+		putKey string
+	}
+)
+
+// WithClock sets the function used to get the current time. Mostly useful
+// for tests.
+func WithClock(now func() time.Time) Option {
+	return func(o *Options) {
+		o.Clock = now
+	}
+}
+
+// WithAudit records the history of every job in the simple_queue_audit
+// table, with the job id, tube, event and time. Rows are written in the
+// same transaction as the change they record, so a change fails if its
+// row cannot be written.
+func WithAudit() Option {
+	return func(o *Options) {
+		o.Audit = true
+	}
+}
+
+// WithInstanceID sets the ID recorded, with a random suffix, as the owner
+// of the maintenance lease when the queue holds it.
+func WithInstanceID(id string) Option {
+	return func(o *Options) {
+		o.InstanceID = id
+	}
+}
+
+// WithMaintenanceBatchSize sets the most expired reservations maintenance
+// reclaims in one transaction. Smaller batches hold locks for less time.
+func WithMaintenanceBatchSize(n int) Option {
+	return func(o *Options) {
+		o.MaintenanceBatchSize = n
+	}
+}
+
+// WithWALMode enables SQLite write-ahead logging.
+func WithWALMode() Option {
+	return func(o *Options) {
+		o.WALMode = true
+	}
+}
+
+// WithBusyTimeout sets how long SQLite waits on a locked database.
+func WithBusyTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.BusyTimeout = d
+	}
+}
+
+// WithMaxCapacity limits the number of jobs in the queue.
+func WithMaxCapacity(n int) Option {
+	return func(o *Options) {
+		o.MaxCapacity = n
+	}
+}
+
+// WithMaxDBSize limits the size in bytes of the database file. Space
+// freed by deleting jobs is only returned by Vacuum.
+func WithMaxDBSize(n int64) Option {
+	return func(o *Options) {
+		o.MaxDBSizeBytes = n
+	}
+}
+
+// WithMaxDataSize limits the size in bytes of a job's data.
+func WithMaxDataSize(n int) Option {
+	return func(o *Options) {
+		o.MaxDataSize = n
+	}
+}
+
+// WithMaxJobSize limits the size in bytes of a job's data. It is the same
+// as WithMaxDataSize. Larger limits than MaxStoredDataSize have no effect,
+// as the database rejects data larger than that.
+func WithMaxJobSize(bytes int) Option {
+	return WithMaxDataSize(bytes)
+}
+
+// WithSelector sets how Reserve chooses jobs.
+func WithSelector(s Selector) Option {
+	return func(o *Options) {
+		o.Selector = s
+	}
+}
+
+// WithPutMiddleware adds middleware around Put. Middleware is applied in
+// the order given, so the first is the outermost.
+func WithPutMiddleware(mw ...PutMiddleware) Option {
+	return func(o *Options) {
+		o.PutMiddleware = append(o.PutMiddleware, mw...)
+	}
+}
+
+// WithDataCodec sets functions to transform job data when it is stored and
+// read, such as for compression or encryption.
+func WithDataCodec(encode, decode func([]byte) ([]byte, error)) Option {
+	return func(o *Options) {
+		o.EncodeData = encode
+		o.DecodeData = decode
+	}
+}
+
+// WithMaxInFlightPerWorker limits the number of jobs a worker may hold
+// reserved with ReserveAs
+func WithMaxInFlightPerWorker(n int) Option {
+	return func(o *Options) {
+		o.MaxInFlightPerWorker = n
+	}
+}
+
+// WithExpiryDeadLetterTube moves jobs whose reservation expires to tube
+func WithExpiryDeadLetterTube(tube string) Option {
+	return func(o *Options) {
+		o.ExpiryDeadLetterTube = tube
+	}
+}
+
+// WithBuriedRetention sets how long buried jobs are kept before
+// maintenance deletes them
+func WithBuriedRetention(d time.Duration) Option {
+	return func(o *Options) {
+		o.BuriedRetention = d
+	}
+}
+
+// WithSharedCache opens the database in SQLite's shared cache mode. This
+// is the same as opening a "file:" URI filename with "cache=shared".
+func WithSharedCache() Option {
+	return func(o *Options) {
+		o.SharedCache = true
+	}
+}
+
+// WithDebugSQL writes every SQL statement run by the queue, with its
+// arguments, row count and duration, to w. It is intended for
+// troubleshooting.
+func WithDebugSQL(w io.Writer) Option {
+	return func(o *Options) {
+		o.DebugSQL = w
+	}
+}
+
+// WithIdempotencyWindow makes Put ignore, without error, a job with the
+// same tube and data as one put within d. Recent puts are remembered in
+// memory, see WithIdempotencyCacheSize, and the key of each job is also
+// stored under a unique index, so concurrent duplicates are ignored too
+// while the first job is still in the queue.
+func WithIdempotencyWindow(d time.Duration) Option {
+	return func(o *Options) {
+		o.IdempotencyWindow = d
+	}
+}
+
+// WithIdempotencyCacheSize sets the number of recent puts remembered for
+// WithIdempotencyWindow.
+func WithIdempotencyCacheSize(n int) Option {
+	return func(o *Options) {
+		o.IdempotencyCacheSize = n
+	}
+}
+
+// WithTubeNameFunc rewrites tube names with fn, so callers can use
+// logical names while jobs are stored under namespaced ones.
+func WithTubeNameFunc(fn func(string) string) Option {
+	return func(o *Options) {
+		o.TubeNameFunc = fn
+	}
+}
+
+// WithRateWindowSize sets the number of put, reserve and delete events
+// remembered per tube for RateOf. Each event remembered costs 8 bytes per
+// tube and op.
+func WithRateWindowSize(n int) Option {
+	return func(o *Options) {
+		o.RateWindowSize = n
+	}
+}
+
+// WithDefaultTimeout fails any statement that takes longer than d with
+// context.DeadlineExceeded, protecting callers from a hung database.
+func WithDefaultTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.DefaultTimeout = d
+	}
+}
+
+// WithContextPropagation stores the trace context of the ctx given to
+// PutContext in the job's metadata using propagator, so workers can
+// continue the trace with propagator.Extract.
+func WithContextPropagation(propagator propagation.TextMapPropagator) Option {
+	return func(o *Options) {
+		o.Propagator = propagator
+	}
+}
+
+// WithOpLog appends a record of every new job to the file at path, which
+// can be used to recover jobs with ReplayLog if the database is lost. This
+// includes jobs put by Put, PutOrUpdate, PutMulti and recurring
+// templates, but not changes to existing jobs, such as the updates made
+// by PutOrUpdate, or jobs put by ReplayLog itself.
+//
+// Each record is, big endian: the creation time in Unix milliseconds
+// (int64), the priority (int64), the TTR in milliseconds (int64), the
+// length of the tube name (uint8), the tube name padded to 255 bytes, the
+// length of the data (uint32) and then the data. Tubes longer than 255
+// bytes cannot be logged, so puts to them return an error.
+func WithOpLog(path string) Option {
+	return func(o *Options) {
+		o.OpLog = path
+	}
+}
+
+// WithTTR sets the time to run with more precision than the ttr argument
+// to Put, which is in seconds.
+func WithTTR(d time.Duration) PutOption {
+	return func(p *putOptions) {
+		p.ttr = d
+	}
+}
+
+// WithTTL sets how long a job may stay ready before it is deleted by
+// maintenance. Zero means forever.
+func WithTTL(d time.Duration) PutOption {
+	return func(p *putOptions) {
+		p.ttl = d
+	}
+}
+
+// WithDelay keeps a job delayed for d before maintenance makes it ready.
+func WithDelay(d time.Duration) PutOption {
+	return func(p *putOptions) {
+		p.delay = d
+	}
+}
+
+// WithMetadata stores md with the job. It is returned in Job.Metadata.
+func WithMetadata(md map[string]string) PutOption {
+	return func(p *putOptions) {
+		p.metadata = md
+	}
+}
+
+// WithTraceID stores a trace or correlation ID with the job. It is
+// returned in Job.TraceID and can be searched for with JobsByTrace.
+func WithTraceID(id string) PutOption {
+	return func(p *putOptions) {
+		p.traceID = id
+	}
+}
+
+func dependsOn(id int) PutOption {
+	return func(p *putOptions) {
+		p.dependsOn = id
+	}
+}
+
+func floatPriority(p float64) PutOption {
+	return func(o *putOptions) {
+		o.score = &p
+	}
+}
+
+// dsn returns the connection string for filename with any driver
+// parameters needed by the options.
+func (o Options) dsn(filename string) string {
+	var params []string
+	// mode and cache are only understood in URI filenames
+	if (o.ReadOnly || o.SharedCache) && !strings.HasPrefix(filename, "file:") {
+		filename = "file:" + filename
+	}
+	if o.ReadOnly {
+		params = append(params, "mode=ro")
+	}
+	if o.SharedCache {
+		params = append(params, "cache=shared")
+	}
+	if o.WALMode {
+		params = append(params, "_journal_mode=WAL")
+	}
+	if o.BusyTimeout > 0 {
+		params = append(params, fmt.Sprintf("_busy_timeout=%d", o.BusyTimeout/time.Millisecond))
+	}
+	if len(params) == 0 {
+		return filename
+	}
+	sep := "?"
+	if strings.Contains(filename, "?") {
+		sep = "&"
+	}
+	return filename + sep + strings.Join(params, "&")
+}
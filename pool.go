@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Pool spreads operations over several Queues opened on the same file,
+// each with its own database connection
+type Pool struct {
+	queues []*Queue
+	next   uint64
+}
+
+// NewPool opens size queues on filename. The database is opened in WAL
+// mode so readers do not block the writer. If no busy timeout is set, a
+// default is used so the queues wait on each other rather than failing.
+func NewPool(filename string, size int, opts ...Option) (*Pool, error) {
+	if size <= 0 {
+		return nil, errors.New("pool size must be positive")
+	}
+
+	o := Options{
+		Buffer:              4,
+		MaintenanceInterval: 3 * time.Second,
+		Clock:               time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.WALMode = true
+	if o.BusyTimeout == 0 {
+		o.BusyTimeout = 5 * time.Second
+	}
+
+	p := &Pool{queues: make([]*Queue, 0, size)}
+	for i := 0; i < size; i++ {
+		q, err := open(filename, o)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.queues = append(p.queues, q)
+	}
+	return p, nil
+}
+
+// queue returns the next queue in round-robin order
+func (p *Pool) queue() *Queue {
+	n := atomic.AddUint64(&p.next, 1)
+	return p.queues[n%uint64(len(p.queues))]
+}
+
+// Put puts a job using the next queue in the pool
+func (p *Pool) Put(tube string, priority int, ttr int, data []byte, opts ...PutOption) error {
+	if err := p.queue().Put(tube, priority, ttr, data, opts...); err != nil {
+		return err
+	}
+	// a reserver may be waiting on any of the queues
+	for _, q := range p.queues {
+		select {
+		case q.wait <- 0:
+		default:
+		}
+	}
+	return nil
+}
+
+// Reserve reserves a job using the next queue in the pool, waiting up to
+// timeout seconds
+func (p *Pool) Reserve(tube string, timeout int) (*Job, error) {
+	return p.queue().Reserve(tube, timeout)
+}
+
+// Close closes all queues in the pool
+func (p *Pool) Close() error {
+	for _, q := range p.queues {
+		q.Close()
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package queue_test
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func withPool(t testing.TB, size int, fn func(p *queue.Pool)) {
+	file := tempfile()
+	p, err := queue.NewPool(file, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(file + suffix)
+		}
+	}()
+	defer p.Close()
+	fn(p)
+}
+
+func TestPool(t *testing.T) {
+	withPool(t, 4, func(p *queue.Pool) {
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for n := 0; n < 25; n++ {
+					if err := p.Put("test", 0, 600, []byte(fmt.Sprint(i, n))); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			ok(t, err)
+		}
+
+		seen := make(map[int]bool)
+		for i := 0; i < 200; i++ {
+			j, err := p.Reserve("test", 0)
+			ok(t, err)
+			assert(t, j != nil, "job %d is nil", i)
+			assert(t, !seen[j.ID], "job %d reserved twice", j.ID)
+			seen[j.ID] = true
+		}
+		j, err := p.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j == nil, "job is not nil")
+	})
+}
+
+func TestPoolReserveWait(t *testing.T) {
+	withPool(t, 4, func(p *queue.Pool) {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			p.Put("test", 0, 600, []byte("testing"))
+		}()
+
+		j, err := p.Reserve("test", 2)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("testing"), j.Data)
+	})
+}
+
+func benchmarkPoolPut(b *testing.B, size int) {
+	withPool(b, size, func(p *queue.Pool) {
+		data := []byte("testing")
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := p.Put("test", 0, 600, data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+func BenchmarkPoolPut1(b *testing.B) { benchmarkPoolPut(b, 1) }
+func BenchmarkPoolPut4(b *testing.B) { benchmarkPoolPut(b, 4) }
@@ -0,0 +1,49 @@
+package queue
+
+import (
+	"fmt"
+	"math"
+)
+
+// Priority is a named priority level for PutLevel. Each level is the
+// lowest numeric priority of a range, so jobs put with a level can still be
+// mixed with numeric priorities.
+type Priority int
+
+const (
+	PriorityLow      Priority = 0
+	PriorityNormal   Priority = 1000
+	PriorityHigh     Priority = 2000
+	PriorityCritical Priority = 3000
+)
+
+// String returns the name of the level
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	case PriorityCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// PutFloat puts a job with a fractional priority, so callers can schedule
+// by a computed score. Jobs put with PutFloat are ordered with those put
+// with integer priorities; the priority column keeps any fraction as a
+// REAL value.
+func (q *Queue) PutFloat(tube string, priority float64, ttr int, data []byte, opts ...PutOption) error {
+	if priority < 0 || math.IsNaN(priority) || math.IsInf(priority, 0) {
+		return fmt.Errorf("invalid priority: %v", priority)
+	}
+	return q.Put(tube, int(priority), ttr, data, append(opts, floatPriority(priority))...)
+}
+
+// PutLevel puts a job with a named priority level
+func (q *Queue) PutLevel(tube string, level Priority, ttr int, data []byte, opts ...PutOption) error {
+	return q.Put(tube, int(level), ttr, data, opts...)
+}
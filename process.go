@@ -0,0 +1,34 @@
+package queue
+
+import (
+	"fmt"
+)
+
+// Process reserves a ready job from tube, without waiting, and calls fn
+// with it. The job is deleted if fn returns nil and released if fn returns
+// an error or panics. The error from fn, or one describing the panic, is
+// returned. Process returns false if there was no job to process.
+func (q *Queue) Process(tube string, fn func(*Job) error) (bool, error) {
+	j, err := q.Reserve(tube, 0)
+	if err != nil || j == nil {
+		return false, err
+	}
+
+	if err := call(fn, j); err != nil {
+		if rerr := j.Release(); rerr != nil {
+			return true, fmt.Errorf("%v; releasing job %d: %v", err, j.ID, rerr)
+		}
+		return true, err
+	}
+	return true, j.Delete()
+}
+
+// call calls fn, turning a panic into an error
+func call(fn func(*Job) error, j *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job %d panicked: %v", j.ID, r)
+		}
+	}()
+	return fn(j)
+}
@@ -0,0 +1,53 @@
+package queue_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestProcess(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		processed, err := q.Process("test", func(j *queue.Job) error {
+			t.Fatal("called without a job")
+			return nil
+		})
+		ok(t, err)
+		assert(t, !processed, "processed without a job")
+
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+		failed := errors.New("failed")
+		processed, err = q.Process("test", func(j *queue.Job) error {
+			return failed
+		})
+		equals(t, failed, err)
+		assert(t, processed, "job not processed")
+		stats, err := q.TubeStats("test")
+		ok(t, err)
+		equals(t, int64(1), stats.Ready)
+
+		processed, err = q.Process("test", func(j *queue.Job) error {
+			panic("boom")
+		})
+		assert(t, err != nil && strings.Contains(err.Error(), "boom"), "unexpected error: %v", err)
+		assert(t, processed, "job not processed")
+		stats, err = q.TubeStats("test")
+		ok(t, err)
+		equals(t, int64(1), stats.Ready)
+
+		var data []byte
+		processed, err = q.Process("test", func(j *queue.Job) error {
+			data = j.Data
+			return nil
+		})
+		ok(t, err)
+		assert(t, processed, "job not processed")
+		equals(t, []byte("testing"), data)
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 0, len(jobs))
+	})
+}
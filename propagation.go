@@ -0,0 +1,29 @@
+package queue
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// PutContext is Put with a context. If the queue was opened with
+// WithContextPropagation, the trace context of ctx is stored in the job's
+// metadata under the keys used by the propagator, such as "traceparent".
+func (q *Queue) PutContext(ctx context.Context, tube string, priority int, ttr int, data []byte) error {
+	var opts []PutOption
+	if q.options.Propagator != nil {
+		carrier := propagation.MapCarrier{}
+		q.options.Propagator.Inject(ctx, carrier)
+		if len(carrier) > 0 {
+			opts = append(opts, WithMetadata(carrier))
+		}
+	}
+	return q.putFunc(ctx, tube, priority, ttr, data, opts...)
+}
+
+// ReserveContext reserves a job from tube, waiting until one is ready or
+// ctx is done. The job's trace context can be recovered from its metadata
+// with propagation.MapCarrier(j.Metadata).
+func (q *Queue) ReserveContext(ctx context.Context, tube string) (*Job, error) {
+	return q.ReserveIf(ctx, tube, func() bool { return true })
+}
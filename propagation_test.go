@@ -0,0 +1,43 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPutContextPropagation(t *testing.T) {
+	propagator := propagation.TraceContext{}
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		sc := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+			SpanID:     trace.SpanID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+			TraceFlags: trace.FlagsSampled,
+		})
+		ctx := trace.ContextWithSpanContext(context.Background(), sc)
+		ok(t, q.PutContext(ctx, "test", 0, 600, []byte("traced")))
+		ok(t, q.PutContext(context.Background(), "test", 0, 600, []byte("untraced")))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		j, err := q.ReserveContext(ctx, "test")
+		ok(t, err)
+		equals(t, []byte("traced"), j.Data)
+		got := trace.SpanContextFromContext(propagator.Extract(context.Background(), propagation.MapCarrier(j.Metadata)))
+		equals(t, sc.TraceID(), got.TraceID())
+		equals(t, sc.SpanID(), got.SpanID())
+		assert(t, got.IsRemote(), "extracted span context should be remote")
+
+		j, err = q.ReserveContext(ctx, "test")
+		ok(t, err)
+		equals(t, []byte("untraced"), j.Data)
+		equals(t, 0, len(j.Metadata))
+
+		_, err = q.ReserveContext(ctx, "test")
+		equals(t, context.DeadlineExceeded, err)
+	}, queue.WithContextPropagation(propagator))
+}
@@ -1,30 +1,138 @@
 package queue
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/BurntSushi/migration"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/bakins/simple-queue/internal/lru"
+	"github.com/mattn/go-sqlite3"
 )
 
 const (
 	STATE_UNKNOWN = iota
 	STATE_READY
 	STATE_RESERVED
+	STATE_DELAYED
+	STATE_BURIED
+)
+
+// reserveOrder is the order in which ready jobs are reserved. seq breaks
+// ties between jobs created in the same millisecond in the order they
+// were committed.
+const reserveOrder = "priority DESC, created ASC, seq ASC, id ASC"
+
+// reverseReserveOrder is reserveOrder reversed
+const reverseReserveOrder = "priority ASC, created DESC, seq DESC, id DESC"
+
+// MaxStoredDataSize is the largest job data, in bytes after EncodeData,
+// that the database accepts regardless of MaxDataSize
+const MaxStoredDataSize = 1 << 20
+
+// maxTTR is the longest TTR, in milliseconds, considered valid by Reclaim
+const maxTTR = 86400 * 365 * 1000
+
+var (
+	// ErrJobNotReady is returned when an operation requires a job in the ready state
+	ErrJobNotReady = errors.New("job not ready")
+	// ErrJobNotReserved is returned when an operation requires a reserved job
+	ErrJobNotReserved = errors.New("job not reserved")
+	// ErrJobNotDelayed is returned by Reschedule for a job that is not
+	// waiting out a delay
+	ErrJobNotDelayed = errors.New("job not delayed")
+	// ErrClosed is returned by Put and Reserve when the queue is closed
+	ErrClosed = errors.New("queue closed")
+	// ErrCircuitOpen is returned by ReserveIf when it is not ready for jobs
+	ErrCircuitOpen = errors.New("circuit open")
+	// ErrJobTooLarge is returned by Put when data exceeds MaxDataSize
+	ErrJobTooLarge = errors.New("job too large")
+	// ErrQueueFull is returned by Put when the queue is at capacity
+	ErrQueueFull = errors.New("queue full")
+	// ErrReadOnly is returned by operations that modify a queue opened
+	// with OpenReadOnly
+	ErrReadOnly = errors.New("queue is read only")
+	// ErrTooManyInFlight is returned by ReserveAs when the worker already
+	// holds MaxInFlightPerWorker jobs
+	ErrTooManyInFlight = errors.New("too many jobs in flight")
+	// ErrEmpty is returned by TryReserve when there is no ready job and
+	// it was not asked to wait
+	ErrEmpty = errors.New("no ready job")
+	// ErrExpiryInPast is returned by PutWithExpiry when the expiry time
+	// has already passed
+	ErrExpiryInPast = errors.New("expiry in the past")
+	// ErrTimeout is returned by TryReserve when there is still no ready
+	// job after waiting
+	ErrTimeout = errors.New("timed out waiting for job")
 )
 
 type (
 	Queue struct {
-		db     *sql.DB
-		ticker *time.Ticker
-		wait   chan struct{}
-		exit   chan struct{}
+		jobsExpired       int64
+		rejectedOversized int64
+		// lastMaintenance is when Maintanence last succeeded, in Unix
+		// nanoseconds
+		lastMaintenance int64
+		db              *sql.DB
+		filename        string
+		ticker          *time.Ticker
+		interval        chan time.Duration
+		wait            chan int
+		exit            chan struct{}
+		closeOnce       sync.Once
+		notifyLock      sync.Mutex
+		notifiers       map[string]map[chan int]struct{}
+		opLog           *opLog
+		stmtLock        sync.Mutex
+		stmts           map[string]*sql.Stmt
+		hookLock        sync.Mutex
+		hooks           map[int]hook
+		observerLock    sync.RWMutex
+		observers       []*observer
+		nextHook        int
+		now             func() time.Time
+		options         Options
+		putFunc         PutFunc
+		recentPuts      *lru.Cache
+		throughput      throughput
+		rates           rates
+		tubeLock        sync.RWMutex
+		tubes           map[string]TubeOptions
+		orderings       map[string]string
+		// leaseOwner names this queue in the maintenance lease and
+		// leaseExpires is when the lease it last took runs out, in Unix
+		// milliseconds. Both are only used by the maintenance goroutine.
+		leaseOwner   string
+		leaseExpires int64
+	}
+
+	// TubeJobSpec describes a job for PutMulti
+	TubeJobSpec struct {
+		Tube     string
+		Priority int
+		TTR      int
+		Data     []byte
+	}
+
+	// Metrics are counters collected since the Queue was opened
+	Metrics struct {
+		JobsExpired int64
+		// RejectedOversized counts puts rejected for exceeding MaxDataSize
+		RejectedOversized int64
 	}
 
 	Tube struct {
 		q    *Queue
 		Name string
+		// DefaultTimeout is the timeout in seconds used by ReserveDefault
+		DefaultTimeout int
 	}
 
 	Job struct {
@@ -37,221 +145,2076 @@ type (
 		Priority uint
 		Data     []byte
 		TTR      time.Duration
+		TTL      time.Duration
+		// ReserveCount is how many times the job has been reserved
+		ReserveCount int
+		// Latency is how long the job waited between being put and
+		// reserved. It is only set by Reserve.
+		Latency time.Duration
+		// Seq orders jobs by when they were put
+		Seq int64
+		// Worker is the id given to ReserveAs by the holder of the job
+		Worker string
+		// ErrorInfo is the reason given when the job was buried
+		ErrorInfo string
+		// Attempts is how many times the job was reserved before this
+		// reservation without being deleted. It is only set by Reserve.
+		Attempts int
+		// PriorityFloat is the priority including any fraction given to
+		// PutFloat. Priority is its integer part.
+		PriorityFloat float64
+		// Metadata is the metadata the job was put with, such as the
+		// trace context stored by PutContext
+		Metadata map[string]string
+		// TraceID is the ID given to WithTraceID
+		TraceID string
 	}
 )
 
-func New(filename string, buffer int, maintanence int) (*Queue, error) {
-	db, err := migration.OpenWith("sqlite3", filename,
-		[]migration.Migrator{
-			func(tx migration.LimitedTx) error {
-				_, err := tx.Exec(`
-               CREATE table simple_queue (
-                 id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-                 tube text NOT NULL,
-                 priority INTEGERT DEFAULT 0,
-                 created INTEGER NOT NULL,
-                 modified INTEGER NOT NULL,
-                 state INTEGER NOT NULL,
-                 data text NOT NULL,
-                 ttr INTEGER NOT NULL
-               )`)
-				return err
-			},
-			func(tx migration.LimitedTx) error {
-				_, err := tx.Exec(`CREATE INDEX simple_queue_tube_idx ON simple_queue(tube)`)
-				return err
-			},
-		},
-		defaultGetVersion,
-		defaultSetVersion)
+// New opens the queue stored in filename, creating it if needed. buffer is
+// the size of the wait channel and maintanence the interval in seconds
+// between maintenance runs. filename may be an SQLite URI such as
+// "file:jobs.db?cache=shared" or "file:jobs?mode=memory&cache=shared".
+func New(filename string, buffer int, maintanence int, opts ...Option) (*Queue, error) {
+	o := Options{
+		Buffer:              buffer,
+		MaintenanceInterval: time.Second * time.Duration(maintanence),
+		Clock:               time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return open(filename, o)
+}
+
+// OpenReadOnly opens an existing queue for reading, such as for
+// reporting. Put, Reserve and Delete return ErrReadOnly.
+func OpenReadOnly(filename string, opts ...Option) (*Queue, error) {
+	o := Options{
+		Buffer:              1,
+		MaintenanceInterval: time.Second,
+		Clock:               time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.ReadOnly = true
+	return open(filename, o)
+}
+
+func open(filename string, o Options) (*Queue, error) {
+	driverName := "sqlite3"
+	if o.DebugSQL != nil || o.DefaultTimeout > 0 {
+		driverName = registerDriver(o)
+	}
+
+	var db *sql.DB
+	var err error
+	if o.ReadOnly {
+		db, err = openReadOnly(driverName, o.dsn(filename))
+	} else {
+		db, err = migration.OpenWith(driverName, o.dsn(filename), migrators(),
+			defaultGetVersion,
+			defaultSetVersion)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var log *opLog
+	if o.OpLog != "" {
+		if log, err = openOpLog(o.OpLog); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	q := &Queue{
+		db:       db,
+		opLog:    log,
+		filename: filename,
+		wait:     make(chan int, o.Buffer),
+		exit:     make(chan struct{}),
+		ticker:   time.NewTicker(o.MaintenanceInterval),
+		interval: make(chan time.Duration),
+		now:      o.Clock,
+		options:  o,
+
+		notifiers: make(map[string]map[chan int]struct{}),
+		stmts:     make(map[string]*sql.Stmt),
+		tubes:     make(map[string]TubeOptions),
+		orderings: make(map[string]string),
+	}
+	q.rates.size = o.RateWindowSize
+	if q.rates.size <= 0 {
+		q.rates.size = defaultRateWindowSize
+	}
+
+	// tube options only affect Put, so a read only queue does not need them
+	if !o.ReadOnly {
+		if err := q.loadTubes(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	if q.options.InstanceID == "" {
+		q.options.InstanceID = randomID()
+	}
+	// queues opened with the same InstanceID, such as by NewPool, must
+	// still hold the lease one at a time
+	q.leaseOwner = q.options.InstanceID + "/" + randomID()
+
+	if o.IdempotencyWindow > 0 {
+		size := o.IdempotencyCacheSize
+		if size <= 0 {
+			size = defaultIdempotencyCacheSize
+		}
+		q.recentPuts = lru.New(size)
+	}
+
+	q.putFunc = q.put
+	for i := len(o.PutMiddleware) - 1; i >= 0; i-- {
+		q.putFunc = o.PutMiddleware[i](q.putFunc)
+	}
+
+	if o.ReadOnly {
+		q.ticker.Stop()
+	} else {
+		go q.maintanence()
+	}
+
+	return q, nil
+}
+
+// openReadOnly opens the database without migrating it
+func openReadOnly(driverName, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// writable returns ErrReadOnly if the queue was opened read only
+func (q *Queue) writable() error {
+	if q.options.ReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// Filename returns the name of the file the queue is stored in
+func (q *Queue) Filename() string {
+	return q.filename
+}
+
+// Options returns the options the queue was opened with
+func (q *Queue) Options() Options {
+	return q.options
+}
+
+func (q *Queue) Maintanence() error {
+	if err := q.writable(); err != nil {
+		return err
+	}
+	now := toMillis(q.now())
+	if err := q.reclaimExpired(now); err != nil {
+		return err
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the expired jobs are only needed for hooks and the audit table
+	var expiring []*Job
+	if q.hasHooks() || q.options.Audit {
+		expiring, err = q.queryJobsTx(tx, "SELECT "+jobColumns+" FROM simple_queue WHERE ttl > 0 AND (created + ttl) < ? AND state=?", now, STATE_READY)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.Exec("DELETE FROM simple_queue WHERE ttl > 0 AND (created + ttl) < ? AND state=?", now, STATE_READY)
+	if err != nil {
+		return err
+	}
+	expired, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if q.options.BuriedRetention > 0 {
+		_, err := tx.Exec("DELETE FROM simple_queue WHERE state=? AND modified < ?",
+			STATE_BURIED, now-toDurationMillis(q.options.BuriedRetention))
+		if err != nil {
+			return err
+		}
+	}
+
+	resolved, err := resolveDependencies(tx, now)
+	if err != nil {
+		return err
+	}
+
+	delayed, err := tx.Exec("UPDATE simple_queue SET state=?, ready_at=NULL WHERE state=? AND ready_at <= ? AND depends_on IS NULL",
+		STATE_READY, STATE_DELAYED, now)
+	if err != nil {
+		return err
+	}
+	promoted, err := delayed.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	recurring, err := q.putRecurring(tx)
+	if err != nil {
+		return err
+	}
+	if err := q.logPuts(recurring...); err != nil {
+		return err
+	}
+	if err := q.audit(tx, eventPut, recurring...); err != nil {
+		return err
+	}
+	if err := q.audit(tx, eventExpire, expiring...); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	atomic.AddInt64(&q.jobsExpired, expired)
+	atomic.StoreInt64(&q.lastMaintenance, q.now().UnixNano())
+	for _, j := range recurring {
+		q.signal(j)
+		q.emit(eventPut, j)
+	}
+	for _, j := range expiring {
+		q.emit(eventExpire, j)
+	}
+	// wake waiting reservers for the jobs whose delay or dependency has
+	// passed
+	q.wake(resolved + promoted)
+	return nil
+}
+
+// wake sends up to n wakeups to waiting reservers without blocking
+func (q *Queue) wake(n int64) {
+	for i := int64(0); i < n; i++ {
+		select {
+		case q.wait <- 0:
+		default:
+			return
+		}
+	}
+}
+
+// reclaimExpired makes reserved jobs whose TTR has passed ready again. It
+// works in batches of Options.MaintenanceBatchSize, each in its own
+// transaction, so the database is not locked for long.
+func (q *Queue) reclaimExpired(now int64) error {
+	size := q.options.MaintenanceBatchSize
+	if size <= 0 {
+		size = defaultMaintenanceBatchSize
+	}
+	for {
+		n, err := q.reclaimBatch(now, size)
+		if err != nil {
+			return err
+		}
+		if n < int64(size) {
+			return nil
+		}
+	}
+}
+
+// reclaimBatch makes up to size reserved jobs whose TTR has passed ready
+// again and emits an expire event for each. It returns the number of jobs
+// reclaimed.
+func (q *Queue) reclaimBatch(now int64, size int) (int64, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	expiring := `(SELECT id FROM simple_queue WHERE state=? AND (modified + ttr) < ? LIMIT ?)`
+	args := []interface{}{STATE_RESERVED, now, size}
+
+	// the expired jobs are only needed for hooks and the audit table
+	var jobs []*Job
+	if q.hasHooks() || q.options.Audit {
+		jobs, err = q.queryJobsTx(tx, "SELECT "+jobColumns+" FROM simple_queue WHERE id IN "+expiring, args...)
+		if err != nil {
+			return 0, err
+		}
+		if len(jobs) == 0 {
+			return 0, nil
+		}
+		ids := make([]string, len(jobs))
+		for i, j := range jobs {
+			ids[i] = strconv.Itoa(j.ID)
+		}
+		expiring = "(" + strings.Join(ids, ",") + ")"
+		args = nil
+	}
+
+	var res sql.Result
+	dlq := q.options.ExpiryDeadLetterTube
+	if dlq != "" {
+		res, err = tx.Exec(`UPDATE simple_queue SET state=?, tube=?, modified=?, worker=NULL WHERE id IN `+expiring,
+			append([]interface{}{STATE_READY, dlq, now}, args...)...)
+	} else {
+		res, err = tx.Exec(`UPDATE simple_queue SET state=?, worker=NULL WHERE id IN `+expiring,
+			append([]interface{}{STATE_READY}, args...)...)
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := q.audit(tx, eventExpire, jobs...); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, j := range jobs {
+		j.State = STATE_READY
+		j.Worker = ""
+		if dlq != "" {
+			j.Tube = dlq
+			j.Modified = fromMillis(now)
+		}
+		q.emit(eventExpire, j)
+	}
+	return n, nil
+}
+
+// ResolveDependencies makes delayed jobs ready once the job they depend on
+// has been deleted. It returns the number of jobs made ready.
+func (q *Queue) ResolveDependencies() (int64, error) {
+	if err := q.writable(); err != nil {
+		return 0, err
+	}
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n, err := resolveDependencies(tx, toMillis(q.now()))
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	q.wake(n)
+	return n, nil
+}
+
+// resolveDependencies makes jobs ready once the job they depend on has
+// been deleted. Jobs still waiting out a delay stay delayed.
+func resolveDependencies(tx *sql.Tx, now int64) (int64, error) {
+	res, err := tx.Exec(`UPDATE simple_queue SET state=?, depends_on=NULL, ready_at=NULL WHERE state=? AND depends_on IS NOT NULL
+                         AND depends_on NOT IN (SELECT id FROM simple_queue) AND (ready_at IS NULL OR ready_at <= ?)`, STATE_READY, STATE_DELAYED, now)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE simple_queue SET depends_on=NULL WHERE state=? AND depends_on IS NOT NULL
+                         AND depends_on NOT IN (SELECT id FROM simple_queue)`, STATE_DELAYED); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DelayedJobs returns the jobs in tube that are waiting out a delay set
+// with WithDelay, soonest first. They can be deleted by ID before they
+// become ready.
+func (q *Queue) DelayedJobs(tube string) ([]*Job, error) {
+	return q.queryJobs("SELECT "+jobColumns+" FROM simple_queue WHERE tube=? AND state=? AND ready_at > ? ORDER BY ready_at, id",
+		q.tubeName(tube), STATE_DELAYED, toMillis(q.now()))
+}
+
+// Metrics returns a snapshot of the queue counters
+func (q *Queue) Metrics() Metrics {
+	return Metrics{
+		JobsExpired:       atomic.LoadInt64(&q.jobsExpired),
+		RejectedOversized: atomic.LoadInt64(&q.rejectedOversized),
+	}
+}
+
+// Reclaim deletes reserved jobs whose TTR is invalid (zero, negative, or
+// longer than a year) and so would never be expired by maintenance.
+// It returns the number of jobs deleted.
+func (q *Queue) Reclaim() (int64, error) {
+	if err := q.writable(); err != nil {
+		return 0, err
+	}
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM simple_queue WHERE state=? AND (ttr <= 0 OR ttr > ?)", STATE_RESERVED, maxTTR)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// PurgeAll deletes every job in every tube and returns the number
+// deleted. The schema is left intact.
+func (q *Queue) PurgeAll() (int, error) {
+	if err := q.writable(); err != nil {
+		return 0, err
+	}
+	res, err := q.db.Exec("DELETE FROM simple_queue")
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Shrink deletes the ready jobs in tube except the keepTopN that Reserve
+// would take first, by priority then age, and returns the number deleted
+func (q *Queue) Shrink(tube string, keepTopN int) (int64, error) {
+	if err := q.writable(); err != nil {
+		return 0, err
+	}
+	if keepTopN < 0 {
+		keepTopN = 0
+	}
+	tube = q.tubeName(tube)
+	res, err := q.db.Exec(`DELETE FROM simple_queue WHERE tube=? AND state=? AND id NOT IN
+                           (SELECT id FROM simple_queue WHERE tube=? AND state=? ORDER BY `+reserveOrder+` LIMIT ?)`,
+		tube, STATE_READY, tube, STATE_READY, keepTopN)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// defaultMaintenanceBatchSize is the number of expired reservations
+// reclaimed per transaction if Options.MaintenanceBatchSize is not set
+const defaultMaintenanceBatchSize = 1000
+
+// redistributeBatch is the most jobs Redistribute moves at a time
+const redistributeBatch = 10
+
+// Redistribute balances ready jobs by repeatedly moving up to 10 from the
+// tube in fromTubes with the most ready jobs to the tube in toTubes with
+// the fewest, until they are about even. The jobs that would be reserved
+// last are moved. It returns the number of jobs moved.
+func (q *Queue) Redistribute(fromTubes []string, toTubes []string) (int64, error) {
+	if err := q.writable(); err != nil {
+		return 0, err
+	}
+	if len(fromTubes) == 0 || len(toTubes) == 0 {
+		return 0, nil
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// pick returns the tube in tubes with the most or fewest ready jobs
+	pick := func(tubes []string, most bool) (string, int64, error) {
+		var best string
+		var bestCount int64 = -1
+		for _, tube := range tubes {
+			tube = q.tubeName(tube)
+			var n int64
+			if err := tx.QueryRow("SELECT COUNT(*) FROM simple_queue WHERE tube=? AND state=?", tube, STATE_READY).Scan(&n); err != nil {
+				return "", 0, err
+			}
+			if bestCount < 0 || (most && n > bestCount) || (!most && n < bestCount) {
+				best, bestCount = tube, n
+			}
+		}
+		return best, bestCount, nil
+	}
+
+	var moved int64
+	for {
+		from, fromCount, err := pick(fromTubes, true)
+		if err != nil {
+			return 0, err
+		}
+		to, toCount, err := pick(toTubes, false)
+		if err != nil {
+			return 0, err
+		}
+		n := (fromCount - toCount) / 2
+		if n <= 0 || from == to {
+			break
+		}
+		if n > redistributeBatch {
+			n = redistributeBatch
+		}
+		res, err := tx.Exec(`UPDATE simple_queue SET tube=? WHERE id IN
+                             (SELECT id FROM simple_queue WHERE tube=? AND state=? ORDER BY `+reverseReserveOrder+` LIMIT ?)`,
+			to, from, STATE_READY, n)
+		if err != nil {
+			return 0, err
+		}
+		n, err = res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		moved += n
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// the moved jobs may be waited for in their new tubes
+	if moved > 0 {
+		select {
+		case q.wait <- 0:
+		default:
+		}
+	}
+	return moved, nil
+}
+
+// Vacuum rebuilds the database file, returning the space freed by deleted
+// jobs to the operating system.
+func (q *Queue) Vacuum() error {
+	if err := q.writable(); err != nil {
+		return err
+	}
+	_, err := q.db.Exec("VACUUM")
+	return err
+}
+
+// CompactDeleted returns the space freed by deleted jobs to the operating
+// system and returns the number of bytes saved. The first call switches
+// the database to incremental vacuuming, which takes a full Vacuum. Later
+// calls only truncate the free pages, which holds the write lock for much
+// less time.
+func (q *Queue) CompactDeleted() (int64, error) {
+	if err := q.writable(); err != nil {
+		return 0, err
+	}
+	// auto_vacuum only applies to the connection it is set on
+	conn, err := q.db.Conn(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	size := func() (int64, error) {
+		var n int64
+		err := conn.QueryRowContext(context.Background(), "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()").Scan(&n)
+		return n, err
+	}
+	before, err := size()
+	if err != nil {
+		return 0, err
+	}
+
+	var mode int
+	if err := conn.QueryRowContext(context.Background(), "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return 0, err
+	}
+	if mode != 2 {
+		if _, err := conn.ExecContext(context.Background(), "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+			return 0, err
+		}
+		if _, err := conn.ExecContext(context.Background(), "VACUUM"); err != nil {
+			return 0, err
+		}
+	} else {
+		// each step of the pragma frees a page, so all rows must be read
+		rows, err := conn.QueryContext(context.Background(), "PRAGMA incremental_vacuum")
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	after, err := size()
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// Truncate deletes the ready jobs in tube except the keepN most recently
+// created and returns the number deleted
+func (q *Queue) Truncate(tube string, keepN int) (int64, error) {
+	if err := q.writable(); err != nil {
+		return 0, err
+	}
+	if keepN < 0 {
+		keepN = 0
+	}
+	tube = q.tubeName(tube)
+	res, err := q.db.Exec(`DELETE FROM simple_queue WHERE tube=? AND state=? AND id NOT IN
+                           (SELECT id FROM simple_queue WHERE tube=? AND state=? ORDER BY created DESC, id DESC LIMIT ?)`,
+		tube, STATE_READY, tube, STATE_READY, keepN)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CheckIntegrity runs an SQLite integrity check and returns an error
+// describing any problems found.
+func (q *Queue) CheckIntegrity() error {
+	rows, err := q.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (q *Queue) maintanence() {
+	interval := q.options.MaintenanceInterval
+LOOP:
+	for {
+		select {
+		case <-q.exit:
+			q.ticker.Stop()
+			break LOOP
+		case <-q.ticker.C:
+			// only one of the queues open on the file runs maintenance
+			if leader, err := q.acquireLease(interval); err == nil && leader {
+				q.Maintanence()
+			}
+		case d := <-q.interval:
+			interval = d
+			q.ticker.Stop()
+			q.ticker = time.NewTicker(d)
+		}
+	}
+}
+
+// Flush runs maintenance, wakes any Reserve calls waiting for the jobs
+// that are now ready and, in WAL mode, checkpoints as much of the log as
+// it can without blocking. It is mostly useful in tests, in place of waiting
+// for the maintenance interval.
+func (q *Queue) Flush() error {
+	if err := q.Maintanence(); err != nil {
+		return err
+	}
+
+	if q.options.WALMode {
+		var busy, log, checkpointed int
+		if err := q.db.QueryRow("PRAGMA wal_checkpoint(PASSIVE)").Scan(&busy, &log, &checkpointed); err != nil {
+			return err
+		}
+	}
+
+	// maintenance does not signal jobs whose reservations expired
+	_, err := q.wakeAll()
+	return err
+}
+
+// WakeAll wakes as many Reserve calls waiting on the queue as there are
+// ready jobs, up to the size of the wait buffer, and returns the number of
+// signals sent. Bulk puts such as PutMulti call it once after committing
+// rather than signalling each job.
+func (q *Queue) WakeAll() int {
+	n, _ := q.wakeAll()
+	return n
+}
+
+// wakeAll is WakeAll returning any error counting the ready jobs
+func (q *Queue) wakeAll() (int, error) {
+	var ready int
+	if err := q.db.QueryRow("SELECT COUNT(*) FROM simple_queue WHERE state=?", STATE_READY).Scan(&ready); err != nil {
+		return 0, err
+	}
+	if ready > cap(q.wait) {
+		ready = cap(q.wait)
+	}
+	for i := 0; i < ready; i++ {
+		select {
+		case q.wait <- 0:
+		default:
+			return i, nil
+		}
+	}
+	return ready, nil
+}
+
+// SetMaintenanceInterval changes how often maintenance runs. d must be at
+// least a second. The next run is d from now.
+func (q *Queue) SetMaintenanceInterval(d time.Duration) error {
+	if d < time.Second {
+		return errors.New("maintenance interval must be at least a second")
+	}
+	if err := q.writable(); err != nil {
+		return err
+	}
+	// the ticker belongs to the maintenance goroutine, so it makes the swap
+	select {
+	case q.interval <- d:
+		return nil
+	case <-q.exit:
+		return ErrClosed
+	}
+}
+
+// DeferredClose closes the queue after d and sends the result of Close on
+// the returned channel. The queue can be used normally until then.
+func (q *Queue) DeferredClose(d time.Duration) <-chan error {
+	ch := make(chan error, 1)
+	time.AfterFunc(d, func() {
+		ch <- q.Close()
+	})
+	return ch
+}
+
+// Close closes the underlying database handle and stops maintainence routines.
+// Any blocked Reserve calls return ErrClosed.
+func (q *Queue) Close() error {
+	q.closeOnce.Do(func() {
+		close(q.exit)
+		q.closeStmts()
+		if !q.options.ReadOnly {
+			q.releaseLease()
+		}
+		q.db.Close()
+		if q.opLog != nil {
+			q.opLog.close()
+		}
+	})
+	return nil
+}
+
+func (q *Queue) Put(tube string, priority int, ttr int, data []byte, opts ...PutOption) error {
+	return q.putFunc(context.Background(), tube, priority, ttr, data, opts...)
+}
+
+// put is the PutFunc wrapped by any PutMiddleware
+func (q *Queue) put(ctx context.Context, tube string, priority int, ttr int, data []byte, opts ...PutOption) error {
+	if err := q.checkPut(); err != nil {
+		return err
+	}
+
+	tube = q.tubeName(tube)
+	var p putOptions
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	key, duplicate := q.recentPut(tube, data)
+	if duplicate {
+		return nil
+	}
+	p.putKey = key
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	j, err := q.insert(tx, tube, priority, ttr, data, p)
+	if err == errDuplicatePut {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := q.logPuts(j); err != nil {
+		return err
+	}
+	if err := q.audit(tx, eventPut, j); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	q.rememberPut(key)
+
+	q.signal(j)
+	q.emit(eventPut, j)
+	return nil
+}
+
+// checkPut returns the error a put fails with before it touches the
+// database
+func (q *Queue) checkPut() error {
+	if err := q.writable(); err != nil {
+		return err
+	}
+	select {
+	case <-q.exit:
+		return ErrClosed
+	default:
+	}
+	return nil
+}
+
+// logPuts appends jobs to the op log, if there is one. It is called
+// before the jobs are committed so a job is never committed without being
+// logged.
+func (q *Queue) logPuts(jobs ...*Job) error {
+	if q.opLog == nil {
+		return nil
+	}
+	for _, j := range jobs {
+		if err := q.opLog.append(j.Created, j.Tube, int(j.Priority), j.TTR, j.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutUpsert replaces the data and priority of the ready job in tube with
+// the given key. If there is no such job, a new one is put.
+func (q *Queue) PutUpsert(tube, key string, priority, ttr int, data []byte) error {
+	_, _, err := q.PutOrUpdate(tube, key, priority, ttr, data)
+	return err
+}
+
+// PutOrUpdate is PutUpsert returning the job. updated is true if an
+// existing ready job was updated rather than a new one put. If the same
+// data was put to tube within the IdempotencyWindow, nothing is done and
+// the job is nil. Only new jobs are written to the op log.
+func (q *Queue) PutOrUpdate(tube string, dedupKey string, priority int, ttr int, data []byte) (*Job, bool, error) {
+	if err := q.checkPut(); err != nil {
+		return nil, false, err
+	}
+	tube = q.tubeName(tube)
+	key, duplicate := q.recentPut(tube, data)
+	if duplicate {
+		return nil, false, nil
+	}
+	stored, err := q.encode(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE simple_queue SET data=?, priority=?, modified=? WHERE tube=? AND dedup_key=? AND state=?",
+		stored, priority, toMillis(q.now()), tube, dedupKey, STATE_READY)
+	if err != nil {
+		return nil, false, q.checkStoredSize(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if n > 0 {
+		jobs, err := q.queryJobsTx(tx, "SELECT "+jobColumns+" FROM simple_queue WHERE tube=? AND dedup_key=? AND state=? ORDER BY id LIMIT 1",
+			tube, dedupKey, STATE_READY)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, false, err
+		}
+		q.rememberPut(key)
+		return jobs[0], true, nil
+	}
+
+	j, err := q.insertEncoded(tx, tube, priority, ttr, data, stored, putOptions{dedupKey: dedupKey, putKey: key})
+	if err == errDuplicatePut {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := q.logPuts(j); err != nil {
+		return nil, false, err
+	}
+	if err := q.audit(tx, eventPut, j); err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	q.rememberPut(key)
+
+	q.signal(j)
+	q.emit(eventPut, j)
+	return j, false, nil
+}
+
+// PutMulti puts jobs into possibly different tubes in a single
+// transaction. Either all jobs are put or none are. It returns the new
+// job ids in the order of specs.
+func (q *Queue) PutMulti(ctx context.Context, specs []TubeJobSpec) ([]int, error) {
+	if err := q.checkPut(); err != nil {
+		return nil, err
+	}
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	jobs := make([]*Job, 0, len(specs))
+	for _, spec := range specs {
+		j, err := q.insert(tx, q.tubeName(spec.Tube), spec.Priority, spec.TTR, spec.Data, putOptions{})
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	if err := q.logPuts(jobs...); err != nil {
+		return nil, err
+	}
+	if err := q.audit(tx, eventPut, jobs...); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(jobs))
+	for i, j := range jobs {
+		ids[i] = j.ID
+		q.notify(j)
+		q.emit(eventPut, j)
+	}
+	q.WakeAll()
+	return ids, nil
+}
+
+// PutWithExpiry puts a job that maintenance deletes if it is still ready
+// at expiresAt
+func (q *Queue) PutWithExpiry(tube string, priority int, ttr int, expiresAt time.Time, data []byte) error {
+	ttl := expiresAt.Sub(q.now())
+	if ttl <= 0 {
+		return ErrExpiryInPast
+	}
+	return q.Put(tube, priority, ttr, data, WithTTL(ttl))
+}
+
+// PutAfter puts a job that is delayed until the job afterJobID is deleted
+// and dependencies are resolved, either by maintenance or
+// ResolveDependencies.
+func (q *Queue) PutAfter(tube string, afterJobID int, priority int, ttr int, data []byte) error {
+	return q.Put(tube, priority, ttr, data, dependsOn(afterJobID))
+}
+
+// insert adds a job within tx
+func (q *Queue) insert(tx *sql.Tx, tube string, priority int, ttr int, data []byte, p putOptions) (*Job, error) {
+	stored, err := q.encode(data)
+	if err != nil {
+		return nil, err
+	}
+	return q.insertEncoded(tx, tube, priority, ttr, data, stored, p)
+}
+
+// insertEncoded is insert with data already passed through encode
+func (q *Queue) insertEncoded(tx *sql.Tx, tube string, priority int, ttr int, data, stored []byte, p putOptions) (*Job, error) {
+	to := q.tubeOptions(tube)
+	if priority == 0 {
+		priority = to.DefaultPriority
+	}
+	ttrMillis := int64(ttr) * 1000
+	if p.ttr > 0 {
+		ttrMillis = toDurationMillis(p.ttr)
+	}
+	if ttrMillis <= 0 {
+		ttrMillis = toDurationMillis(to.DefaultTTR)
+	}
+	if ttrMillis <= 0 {
+		ttrMillis = 1000
+	}
+	ttl := toDurationMillis(p.ttl)
+	score := float64(priority)
+	var column interface{} = priority
+	if p.score != nil {
+		score = *p.score
+		column = score
+	}
+
+	if q.options.MaxCapacity > 0 {
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) from simple_queue").Scan(&count); err != nil {
+			return nil, err
+		}
+		if count >= q.options.MaxCapacity {
+			return nil, ErrQueueFull
+		}
+	}
+	if q.options.MaxDBSizeBytes > 0 {
+		var size int64
+		if err := tx.QueryRow("SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()").Scan(&size); err != nil {
+			return nil, err
+		}
+		if size >= q.options.MaxDBSizeBytes {
+			return nil, ErrQueueFull
+		}
+	}
+	if to.MaxCapacity > 0 {
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) from simple_queue WHERE tube=?", tube).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count >= to.MaxCapacity {
+			return nil, ErrQueueFull
+		}
+	}
+
+	var key interface{}
+	if p.dedupKey != "" {
+		key = p.dedupKey
+	}
+	var metadata interface{}
+	if len(p.metadata) > 0 {
+		b, err := json.Marshal(p.metadata)
+		if err != nil {
+			return nil, err
+		}
+		metadata = string(b)
+	}
+	var traceID interface{}
+	if p.traceID != "" {
+		traceID = p.traceID
+	}
+	var putKey interface{}
+	if p.putKey != "" {
+		// a job put before the window gives up its key, so the unique
+		// index only rejects recent duplicates
+		cutoff := toMillis(q.now().Add(-q.options.IdempotencyWindow))
+		if _, err := tx.Exec("UPDATE simple_queue SET put_key=NULL WHERE put_key=? AND created < ?", p.putKey, cutoff); err != nil {
+			return nil, err
+		}
+		putKey = p.putKey
+	}
+
+	state := STATE_READY
+	var dependsOn interface{}
+	if p.dependsOn > 0 {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM simple_queue WHERE id=?)", p.dependsOn).Scan(&exists); err != nil {
+			return nil, err
+		}
+		// if the dependency is already gone the job is ready now
+		if exists {
+			state = STATE_DELAYED
+			dependsOn = p.dependsOn
+		}
+	}
+
+	now := toMillis(q.now())
+	var readyAt interface{}
+	if p.delay > 0 {
+		state = STATE_DELAYED
+		readyAt = now + toDurationMillis(p.delay)
+	}
+
+	// seq is assigned within the transaction, so it follows commit order
+	stmt, err := q.stmt(tx, `INSERT into simple_queue (tube, created, modified, state, data, ttr, priority, ttl, dedup_key, depends_on, ready_at, metadata, trace_id, put_key, seq)
+                             VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(seq), 0) + 1 FROM simple_queue))
+                             ON CONFLICT(put_key) DO NOTHING`)
+	if err != nil {
+		return nil, err
+	}
+	res, err := stmt.Exec(tube, now, now, state, stored, ttrMillis, column, ttl, key, dependsOn, readyAt, metadata, traceID, putKey)
+	if err != nil {
+		return nil, q.checkStoredSize(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, errDuplicatePut
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{
+		q:             q,
+		ID:            int(id),
+		Tube:          tube,
+		Created:       fromMillis(now),
+		Modified:      fromMillis(now),
+		State:         state,
+		Priority:      uint(priority),
+		PriorityFloat: score,
+		Metadata:      p.metadata,
+		TraceID:       p.traceID,
+		Data:          data,
+		TTR:           fromDurationMillis(ttrMillis),
+		TTL:           fromDurationMillis(ttl),
+	}, nil
+}
+
+// encode applies EncodeData to data and checks the size of the result
+func (q *Queue) encode(data []byte) ([]byte, error) {
+	if q.options.EncodeData != nil {
+		var err error
+		if data, err = q.options.EncodeData(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := q.checkSize(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// checkSize returns ErrJobTooLarge if data exceeds MaxDataSize
+func (q *Queue) checkSize(data []byte) error {
+	if q.options.MaxDataSize > 0 && len(data) > q.options.MaxDataSize {
+		atomic.AddInt64(&q.rejectedOversized, 1)
+		return ErrJobTooLarge
+	}
+	return nil
+}
+
+// checkStoredSize converts the error raised by the database for data
+// larger than MaxStoredDataSize to ErrJobTooLarge
+func (q *Queue) checkStoredSize(err error) error {
+	if e, ok := err.(sqlite3.Error); ok && e.ExtendedCode == sqlite3.ErrConstraintTrigger && strings.Contains(e.Error(), ErrJobTooLarge.Error()) {
+		atomic.AddInt64(&q.rejectedOversized, 1)
+		return ErrJobTooLarge
+	}
+	return err
+}
+
+// signal wakes a waiting Reserve and any notifiers for the job's tube
+// when j is ready. Signals are coalesced: if the wait buffer is already
+// full, reservers have pending wakeups and the signal is dropped rather
+// than blocking the caller.
+func (q *Queue) signal(j *Job) {
+	if j.State != STATE_READY {
+		return
+	}
+
+	select {
+	case q.wait <- j.ID:
+	default:
+	}
+	q.notify(j)
+}
+
+// notify sends the id of a ready job to the Notify channels for its tube
+func (q *Queue) notify(j *Job) {
+	if j.State != STATE_READY {
+		return
+	}
+
+	q.notifyLock.Lock()
+	defer q.notifyLock.Unlock()
+	for ch := range q.notifiers[j.Tube] {
+		select {
+		case ch <- j.ID:
+		default:
+		}
+	}
+}
+
+// Notify returns a channel that receives the ids of jobs in tube as they
+// are put or released. Notifications are dropped if the receiver falls
+// behind. The channel is closed when ctx is done or the queue is closed.
+func (q *Queue) Notify(ctx context.Context, tube string) (<-chan int, error) {
+	select {
+	case <-q.exit:
+		return nil, ErrClosed
+	default:
+	}
+
+	tube = q.tubeName(tube)
+	ch := make(chan int, q.options.Buffer+1)
+	q.notifyLock.Lock()
+	if q.notifiers[tube] == nil {
+		q.notifiers[tube] = make(map[chan int]struct{})
+	}
+	q.notifiers[tube][ch] = struct{}{}
+	q.notifyLock.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-q.exit:
+		}
+		q.notifyLock.Lock()
+		defer q.notifyLock.Unlock()
+		delete(q.notifiers[tube], ch)
+		if len(q.notifiers[tube]) == 0 {
+			delete(q.notifiers, tube)
+		}
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+func (q *Queue) Reserve(tube string, timeout int) (*Job, error) {
+	return q.ReserveAs(tube, "", timeout)
+}
+
+// ReserveAs is like Reserve but records workerID as the holder of the
+// job. It is cleared when the job is released or its reservation expires.
+func (q *Queue) ReserveAs(tube, workerID string, timeout int) (*Job, error) {
+	if timeout > 0 {
+		select {
+		case <-q.wait:
+		case <-time.After(time.Second * time.Duration(timeout)):
+		case <-q.exit:
+			return nil, ErrClosed
+		}
+	} else {
+		select {
+		case <-q.exit:
+			return nil, ErrClosed
+		default:
+		}
+	}
+
+	return q.reserve(tube, workerID)
+}
+
+// TryReserve is like Reserve but returns an error rather than a nil job
+// when there is nothing to reserve: ErrEmpty if timeout is zero, or
+// ErrTimeout after waiting timeout seconds. Unlike Reserve, it keeps
+// waiting when woken by a job it cannot reserve.
+func (q *Queue) TryReserve(tube string, timeout int) (*Job, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(time.Second * time.Duration(timeout))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		select {
+		case <-q.exit:
+			return nil, ErrClosed
+		default:
+		}
+
+		j, err := q.reserve(tube, "")
+		if err != nil || j != nil {
+			return j, err
+		}
+		if deadline == nil {
+			return nil, ErrEmpty
+		}
+
+		select {
+		case <-q.wait:
+		case <-deadline:
+			// a job may have arrived without a wakeup reaching us
+			if j, err := q.reserve(tube, ""); err != nil || j != nil {
+				return j, err
+			}
+			return nil, ErrTimeout
+		case <-q.exit:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// ReserveIf reserves a job only while ready returns true, otherwise it
+// returns ErrCircuitOpen without touching the database. It waits for a job
+// until ctx is done, checking ready again each time it wakes.
+func (q *Queue) ReserveIf(ctx context.Context, tube string, ready func() bool) (*Job, error) {
+	for {
+		if !ready() {
+			return nil, ErrCircuitOpen
+		}
+
+		j, err := q.reserve(tube, "")
+		if err != nil || j != nil {
+			return j, err
+		}
+
+		select {
+		case <-q.wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.exit:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// MultiReserve reserves up to count jobs from tube in a single
+// transaction. If there are no ready jobs it waits up to timeout seconds
+// for one. Fewer than count jobs, or none, may be returned.
+func (q *Queue) MultiReserve(tube string, count int, timeout int) ([]*Job, error) {
+	if count < 0 {
+		return nil, errors.New("count must not be negative")
+	}
+	select {
+	case <-q.exit:
+		return nil, ErrClosed
+	default:
+	}
+	if count == 0 {
+		return []*Job{}, nil
+	}
+
+	jobs, err := q.reserveN(tube, count, "")
+	if err != nil || len(jobs) > 0 || timeout <= 0 {
+		return jobs, err
+	}
+
+	select {
+	case <-q.wait:
+	case <-time.After(time.Second * time.Duration(timeout)):
+	case <-q.exit:
+		return nil, ErrClosed
+	}
+	return q.reserveN(tube, count, "")
+}
+
+// ReserveAny reserves a job from the first of tubes that has one ready,
+// skipping tubes at their TubeOptions.MaxInFlight limit. If none do, it
+// waits up to timeout seconds for a job to be put and checks them again.
+// It returns nil if there is still no job.
+func (q *Queue) ReserveAny(tubes []string, timeout int) (*Job, error) {
+	select {
+	case <-q.exit:
+		return nil, ErrClosed
+	default:
+	}
+
+	j, err := q.reserveAny(tubes)
+	if err != nil || j != nil || timeout <= 0 {
+		return j, err
+	}
+
+	select {
+	case <-q.wait:
+	case <-time.After(time.Second * time.Duration(timeout)):
+	case <-q.exit:
+		return nil, ErrClosed
+	}
+	return q.reserveAny(tubes)
+}
+
+func (q *Queue) reserveAny(tubes []string) (*Job, error) {
+	for _, tube := range tubes {
+		j, err := q.reserve(tube, "")
+		if err != nil || j != nil {
+			return j, err
+		}
+	}
+	return nil, nil
+}
+
+// ReserveAt reserves the ready job in tube at offset in the reserve
+// ordering, counting from 0, such as for an operator to inspect a
+// particular job. If there are not enough ready jobs, it waits up to
+// timeout seconds for a job to be put and tries again. It returns nil if
+// there is still no job at offset.
+func (q *Queue) ReserveAt(tube string, offset, timeout int) (*Job, error) {
+	if offset < 0 {
+		return nil, errors.New("offset must not be negative")
+	}
+	jobs, err := q.reserveFrom(tube, offset, 1, "")
+	if err != nil || len(jobs) > 0 || timeout <= 0 {
+		return first(jobs), err
+	}
+
+	select {
+	case <-q.wait:
+	case <-time.After(time.Second * time.Duration(timeout)):
+	case <-q.exit:
+		return nil, ErrClosed
+	}
+	jobs, err = q.reserveFrom(tube, offset, 1, "")
+	return first(jobs), err
+}
+
+// first returns the first job, or nil if there are none
+func first(jobs []*Job) *Job {
+	if len(jobs) == 0 {
+		return nil
+	}
+	return jobs[0]
+}
+
+// reserve reserves the next ready job in tube for worker without waiting.
+// It returns nil if there is none.
+func (q *Queue) reserve(tube, worker string) (*Job, error) {
+	jobs, err := q.reserveN(tube, 1, worker)
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return jobs[0], nil
+}
+
+// reserveN reserves up to n ready jobs in tube for worker without waiting
+func (q *Queue) reserveN(tube string, n int, worker string) ([]*Job, error) {
+	return q.reserveFrom(tube, 0, n, worker)
+}
+
+// reserveFrom is reserveN skipping the first offset ready jobs
+func (q *Queue) reserveFrom(tube string, offset, n int, worker string) ([]*Job, error) {
+	if err := q.writable(); err != nil {
+		return nil, err
+	}
+	tube = q.tubeName(tube)
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if max := q.options.MaxInFlightPerWorker; max > 0 && worker != "" {
+		var held int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM simple_queue WHERE worker=? AND state=?", worker, STATE_RESERVED).Scan(&held); err != nil {
+			return nil, err
+		}
+		if held >= max {
+			return nil, ErrTooManyInFlight
+		}
+		if n > max-held {
+			n = max - held
+		}
+	}
+	if max := q.tubeOptions(tube).MaxInFlight; max > 0 {
+		var held int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM simple_queue WHERE tube=? AND state=?", tube, STATE_RESERVED).Scan(&held); err != nil {
+			return nil, err
+		}
+		if held >= max {
+			return []*Job{}, nil
+		}
+		if n > max-held {
+			n = max - held
+		}
+	}
+
+	query, args := q.reserveQuery(tube, n, offset)
+	stmt, err := q.stmt(tx, query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, 0, n)
+	for rows.Next() {
+		j, err := q.scanJob(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return jobs, nil
+	}
+
+	now := q.now()
+	ids := make([]string, len(jobs))
+	for i, j := range jobs {
+		ids[i] = strconv.Itoa(j.ID)
+		j.Modified = now
+		j.State = STATE_RESERVED
+		j.ReserveCount++
+		j.Attempts = j.ReserveCount - 1
+		j.Latency = now.Sub(j.Created)
+		j.Worker = worker
+	}
+	var workerID interface{}
+	if worker != "" {
+		workerID = worker
+	}
+	_, err = tx.Exec("UPDATE simple_queue SET state=?, modified=?, reserve_count=reserve_count+1, worker=? WHERE id IN ("+strings.Join(ids, ",")+")",
+		STATE_RESERVED, toMillis(now), workerID)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.audit(tx, eventReserve, jobs...); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		q.emit(eventReserve, j)
+	}
+	return jobs, nil
+}
+
+// Jobs returns all Jobs in a tube
+func (q *Queue) Jobs(tube string) ([]*Job, error) {
+	return q.jobs("WHERE tube=? ORDER BY "+reserveOrder, q.tubeName(tube))
+}
+
+// JobsByPriorityRange returns the jobs in tube with state whose priority
+// is between minPriority and maxPriority inclusive, in reserve order
+func (q *Queue) JobsByPriorityRange(tube string, minPriority, maxPriority uint, state int) ([]*Job, error) {
+	if minPriority > maxPriority {
+		return nil, errors.New("minPriority must not be greater than maxPriority")
+	}
+	return q.jobs("WHERE tube=? AND state=? AND priority BETWEEN ? AND ? ORDER BY "+reserveOrder,
+		q.tubeName(tube), state, minPriority, maxPriority)
+}
+
+// Rotate moves the ready job in tube that would be reserved next behind
+// the other ready jobs of the same priority, by making it the newest. Its
+// TTL, if any, starts again. The job is returned still ready, or nil if
+// there are no ready jobs.
+func (q *Queue) Rotate(tube string) (*Job, error) {
+	if err := q.writable(); err != nil {
+		return nil, err
+	}
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	if err != nil {
+	jobs, err := q.queryJobsTx(tx, "SELECT "+jobColumns+" FROM simple_queue WHERE tube=? AND state=? ORDER BY "+reserveOrder+" LIMIT 1",
+		q.tubeName(tube), STATE_READY)
+	if err != nil || len(jobs) == 0 {
 		return nil, err
 	}
+	j := jobs[0]
 
-	q := &Queue{
-		db:     db,
-		wait:   make(chan struct{}, buffer),
-		exit:   make(chan struct{}),
-		ticker: time.NewTicker(time.Second * time.Duration(maintanence)),
+	now := q.now()
+	if _, err := tx.Exec("UPDATE simple_queue SET created=?, seq=(SELECT MAX(seq) + 1 FROM simple_queue) WHERE id=?", toMillis(now), j.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow("SELECT seq FROM simple_queue WHERE id=?", j.ID).Scan(&j.Seq); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
+	j.Created = now
+	return j, nil
+}
 
-	go q.maintanence()
+// FirstReady returns the ready job in tube that would be reserved next by
+// the default ordering, or nil if there is none. It is not reserved.
+func (q *Queue) FirstReady(tube string) (*Job, error) {
+	return q.NthReady(tube, 1)
+}
 
-	return q, nil
+// LastReady returns the ready job in tube that would be reserved last by
+// the default ordering, or nil if there is none
+func (q *Queue) LastReady(tube string) (*Job, error) {
+	return q.readyAt(tube, reverseReserveOrder, 0)
 }
 
-func (q *Queue) Maintanence() error {
-	tx, err := q.db.Begin()
-	if err != nil {
-		return err
+// NthReady returns the nth ready job in tube, counting from 1, in the
+// default reserve ordering, or nil if there are fewer than n
+func (q *Queue) NthReady(tube string, n int) (*Job, error) {
+	if n < 1 {
+		return nil, errors.New("n must be at least 1")
 	}
-	defer tx.Rollback()
+	return q.readyAt(tube, reserveOrder, n-1)
+}
 
-	_, err = tx.Exec("UPDATE simple_queue SET state=? WHERE state=? AND (modified + ttr) < ?", STATE_READY, STATE_RESERVED, time.Now().Unix())
-	if err != nil {
-		return err
+// readyAt returns the ready job in tube at offset in order
+func (q *Queue) readyAt(tube, order string, offset int) (*Job, error) {
+	jobs, err := q.jobs("WHERE tube=? AND state=? ORDER BY "+order+" LIMIT 1 OFFSET ?", q.tubeName(tube), STATE_READY, offset)
+	if err != nil || len(jobs) == 0 {
+		return nil, err
 	}
-
-	return tx.Commit()
+	return jobs[0], nil
 }
 
-func (q *Queue) maintanence() {
-LOOP:
-	for {
-		select {
-		case <-q.exit:
-			q.ticker.Stop()
-			break LOOP
-		case <-q.ticker.C:
-			q.Maintanence()
-		}
-	}
+// JobExists returns true if there is a job with id
+func (q *Queue) JobExists(id int) (bool, error) {
+	var exists bool
+	err := q.db.QueryRow("SELECT EXISTS(SELECT 1 FROM simple_queue WHERE id=?)", id).Scan(&exists)
+	return exists, err
 }
 
-// Close closes the underlying database handle and stops maintainence routines
-func (q *Queue) Close() error {
-	close(q.wait)
-	q.exit <- struct{}{}
-	q.db.Close()
-	return nil
+// JobByID returns the job with id, or nil if there is none
+func (q *Queue) JobByID(id int) (*Job, error) {
+	jobs, err := q.jobs("WHERE id=?", id)
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return jobs[0], nil
 }
 
-func (q *Queue) Put(tube string, priority int, ttr int, data []byte) error {
-	if ttr <= 0 {
-		ttr = 1
+// ForceExpire ends the reservation of the job with id without waiting for
+// its TTR, such as to reclaim a job from a worker that crashed. The job is
+// ready again immediately, and an expire event is emitted as if its TTR
+// had passed. It returns ErrJobNotReserved if the job is not reserved.
+func (q *Queue) ForceExpire(id int) error {
+	if err := q.writable(); err != nil {
+		return err
 	}
-	now := time.Now().Unix()
 	tx, err := q.db.Begin()
+	if err != nil {
+		return err
+	}
 	defer tx.Rollback()
-	_, err = tx.Exec("INSERT into simple_queue (tube, created, modified, state, data, ttr, priority) VALUES(?, ?, ?, ?, ?, ?, ?)",
-		tube, now, now, STATE_READY, data, ttr, priority)
+
+	// a modified time of 0 means the TTR has passed however it is checked
+	res, err := tx.Exec("UPDATE simple_queue SET state=?, modified=0, worker=NULL WHERE id=? AND state=?",
+		STATE_READY, id, STATE_RESERVED)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotReserved
+	}
+	jobs, err := q.queryJobsTx(tx, "SELECT "+jobColumns+" FROM simple_queue WHERE id=?", id)
 	if err != nil {
 		return err
 	}
+	j := jobs[0]
+	if err := q.audit(tx, eventExpire, j); err != nil {
+		return err
+	}
 	if err := tx.Commit(); err != nil {
 		return err
 	}
 
-	q.wait <- struct{}{}
+	q.signal(j)
+	q.emit(eventExpire, j)
 	return nil
 }
 
-func (q *Queue) Reserve(tube string, timeout int) (*Job, error) {
+// JobsByWorker returns the jobs reserved by workerID with ReserveAs
+func (q *Queue) JobsByWorker(workerID string) ([]*Job, error) {
+	return q.jobs("WHERE worker=? AND state=? ORDER BY modified ASC, id ASC", workerID, STATE_RESERVED)
+}
 
-	if timeout > 0 {
-		select {
-		case <-q.wait:
-		case <-time.After(time.Second * time.Duration(timeout)):
-		}
-	}
+// JobsByTrace returns the jobs put with WithTraceID(traceID), in the
+// order they were put
+func (q *Queue) JobsByTrace(traceID string) ([]*Job, error) {
+	return q.jobs("WHERE trace_id=? ORDER BY seq ASC, id ASC", traceID)
+}
 
-	tx, err := q.db.Begin()
-	if err != nil {
+// BuriedWithErrors returns the buried jobs in a tube that have error info
+func (q *Queue) BuriedWithErrors(tube string) ([]*Job, error) {
+	return q.jobs("WHERE tube=? AND state=? AND error_info IS NOT NULL AND error_info != '' ORDER BY modified ASC, id ASC",
+		q.tubeName(tube), STATE_BURIED)
+}
+
+// BuriedCount returns the number of buried jobs in all tubes
+func (q *Queue) BuriedCount() (int, error) {
+	var n int
+	err := q.db.QueryRow("SELECT COUNT(*) FROM simple_queue WHERE state=?", STATE_BURIED).Scan(&n)
+	return n, err
+}
+
+// FrequentlyReserved returns ready jobs in a tube that have been reserved
+// at least threshold times
+func (q *Queue) FrequentlyReserved(tube string, threshold int) ([]*Job, error) {
+	return q.jobs("WHERE tube=? AND state=? AND reserve_count >= ? ORDER BY "+reserveOrder,
+		q.tubeName(tube), STATE_READY, threshold)
+}
 
+// NextPerTube returns the job that would next be reserved from each tube
+// that has ready jobs, keyed by tube
+func (q *Queue) NextPerTube() (map[string]*Job, error) {
+	jobs, err := q.queryJobs(`SELECT `+jobColumns+` FROM (
+                 SELECT *, ROW_NUMBER() OVER (PARTITION BY tube ORDER BY `+reserveOrder+`) AS rank
+                 FROM simple_queue WHERE state=?
+               ) WHERE rank=1`, STATE_READY)
+	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
-	row := tx.QueryRow("SELECT id, created, data, ttr, priority from simple_queue WHERE tube=? AND state=? ORDER BY priority DESC, created ASC LIMIT 1",
-		tube, STATE_READY)
 
-	now := time.Now()
-	j := Job{
-		q:        q,
-		Tube:     tube,
-		Modified: now,
-		State:    STATE_RESERVED,
+	next := make(map[string]*Job, len(jobs))
+	for _, j := range jobs {
+		next[j.Tube] = j
 	}
+	return next, nil
+}
 
-	var created, ttr int64
-	if err := row.Scan(&j.ID, &created, &j.Data, &ttr, &j.Priority); err != nil {
-		if err == sql.ErrNoRows {
-			err = nil
-		}
-		return nil, err
+// secondsCutoff separates timestamps stored in seconds by older versions
+// from those stored in milliseconds. As milliseconds it is in 1973, as
+// seconds it is far in the future.
+const secondsCutoff = 100000000000
+
+// toMillis converts t to Unix milliseconds, as timestamps are stored
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// fromMillis converts a stored timestamp to a time
+func fromMillis(ms int64) time.Time {
+	if ms < secondsCutoff {
+		return time.Unix(ms, 0)
 	}
-	j.Created = time.Unix(created, 0)
-	j.TTR = time.Second * time.Duration(ttr)
-	_, err = tx.Exec("UPDATE simple_queue SET state=?, modified=? WHERE id=?", STATE_RESERVED, now.Unix(), j.ID)
-	if err != nil {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// toDurationMillis converts d to milliseconds, rounding up so that a
+// positive duration is never stored as zero
+func toDurationMillis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+func fromDurationMillis(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// jobColumns are the columns read by scanJob
+const jobColumns = "id, tube, created, modified, state, priority, data, ttr, ttl, reserve_count, COALESCE(error_info, ''), COALESCE(worker, ''), seq, COALESCE(metadata, ''), COALESCE(trace_id, '')"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanJob reads a job selected with jobColumns
+func (q *Queue) scanJob(row scanner) (*Job, error) {
+	j := Job{q: q}
+	var created, modified, ttr, ttl int64
+	var metadata string
+	if err := row.Scan(&j.ID, &j.Tube, &created, &modified, &j.State, &j.PriorityFloat, &j.Data, &ttr, &ttl, &j.ReserveCount, &j.ErrorInfo, &j.Worker, &j.Seq, &metadata, &j.TraceID); err != nil {
 		return nil, err
 	}
-	if err := tx.Commit(); err != nil {
+	if metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &j.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	j.Priority = uint(j.PriorityFloat)
+	j.Created = fromMillis(created)
+	j.Modified = fromMillis(modified)
+	j.TTR = fromDurationMillis(ttr)
+	j.TTL = fromDurationMillis(ttl)
+	var err error
+	if j.Data, err = q.decode(j.Data); err != nil {
 		return nil, err
 	}
 	return &j, nil
 }
 
-// Jobs returns all Jobs in a tube
-func (q *Queue) Jobs(tube string) ([]*Job, error) {
+// decode reverses encode
+func (q *Queue) decode(data []byte) ([]byte, error) {
+	if q.options.DecodeData != nil {
+		return q.options.DecodeData(data)
+	}
+	return data, nil
+}
+
+// jobs returns the jobs matching the where clause, which may also contain
+// ordering and limits
+func (q *Queue) jobs(where string, args ...interface{}) ([]*Job, error) {
+	return q.queryJobs("SELECT "+jobColumns+" from simple_queue "+where, args...)
+}
+
+// queryJobs returns the jobs selected by query, which must select jobColumns
+func (q *Queue) queryJobs(query string, args ...interface{}) ([]*Job, error) {
 	tx, err := q.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
+	jobs, err := q.queryJobsTx(tx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return jobs, tx.Commit()
+}
+
+// queryJobsTx is queryJobs within tx
+func (q *Queue) queryJobsTx(tx *sql.Tx, query string, args ...interface{}) ([]*Job, error) {
 	jobs := make([]*Job, 0)
-	rows, err := tx.Query("SELECT id, created, modified, data, ttr, state, priority from simple_queue WHERE tube=? ORDER BY priority DESC, created ASC",
-		tube)
+	rows, err := tx.Query(query, args...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return jobs, nil
 		}
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		j := Job{Tube: tube}
-		var modified, created, ttr int64
-		if err := rows.Scan(&j.ID, &modified, &created, &j.Data, &ttr, &j.State, &j.Priority); err != nil {
+		j, err := q.scanJob(rows)
+		if err != nil {
 			return nil, err
 		}
-		j.Modified = time.Unix(modified, 0)
-		j.Created = time.Unix(created, 0)
-		j.TTR = time.Second * time.Duration(ttr)
-		jobs = append(jobs, &j)
+		jobs = append(jobs, j)
 	}
+	return jobs, rows.Err()
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+// SwapPriority exchanges the priorities of two ready jobs
+func (q *Queue) SwapPriority(id1, id2 int) error {
+	if err := q.writable(); err != nil {
+		return err
+	}
+	tx, err := q.db.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	if err := tx.Commit(); err != nil {
-		return nil, err
+	var p1, p2 interface{}
+	if err := tx.QueryRow("SELECT priority from simple_queue WHERE id=? AND state=?", id1, STATE_READY).Scan(&p1); err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrJobNotReady
+		}
+		return err
+	}
+	if err := tx.QueryRow("SELECT priority from simple_queue WHERE id=? AND state=?", id2, STATE_READY).Scan(&p2); err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrJobNotReady
+		}
+		return err
 	}
 
-	return jobs, nil
+	if _, err := tx.Exec("UPDATE simple_queue SET priority=? WHERE id=?", p2, id1); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE simple_queue SET priority=? WHERE id=?", p1, id2); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Age returns how long ago the job was put. A job not attached to a
+// queue, such as after UnmarshalBinary, uses the system clock.
+func (j *Job) Age() time.Duration {
+	if j.q == nil {
+		return time.Since(j.Created)
+	}
+	return j.q.now().Sub(j.Created)
 }
 
 // Delete removes a job
 func (j *Job) Delete() error {
+	if err := j.q.writable(); err != nil {
+		return err
+	}
+
 	tx, err := j.q.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec("DELETE from simple_queue WHERE id=?", j.ID)
+	stmt, err := j.q.stmt(tx, "DELETE from simple_queue WHERE id=?")
 	if err != nil {
 		return err
 	}
-	return tx.Commit()
+	_, err = stmt.Exec(j.ID)
+	if err != nil {
+		return err
+	}
+	if err := j.q.audit(tx, eventDelete, j); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	j.q.throughput.record(j.q.now())
+	j.q.emit(eventDelete, j)
+	return nil
+
+}
+
+// DeleteIfReserved deletes the job only if it is still held by this
+// reservation. If it was released, expired or reserved again since, such
+// as by another worker, it returns ErrJobNotReserved and the job is kept.
+func (j *Job) DeleteIfReserved() error {
+	if err := j.q.writable(); err != nil {
+		return err
+	}
+	tx, err := j.q.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the reserve count and modified time identify the reservation
+	res, err := tx.Exec("DELETE from simple_queue WHERE id=? AND state=? AND reserve_count=? AND modified=?",
+		j.ID, STATE_RESERVED, j.ReserveCount, toMillis(j.Modified))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotReserved
+	}
+	if err := j.q.audit(tx, eventDelete, j); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	j.q.throughput.record(j.q.now())
+	j.q.emit(eventDelete, j)
+	return nil
+}
+
+// Release puts a reserved job back into the ready state
+func (j *Job) Release() error {
+	if err := j.q.writable(); err != nil {
+		return err
+	}
+	tx, err := j.q.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := j.q.now()
+	res, err := tx.Exec("UPDATE simple_queue SET state=?, modified=?, worker=NULL WHERE id=? AND state=?", STATE_READY, toMillis(now), j.ID, STATE_RESERVED)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotReserved
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	j.State = STATE_READY
+	j.Modified = now
+	j.Worker = ""
+
+	j.q.signal(j)
+	return nil
+}
+
+// Bury moves a reserved job to the buried state, recording reason. Buried
+// jobs are not reserved or expired.
+func (j *Job) Bury(reason string) error {
+	if err := j.q.writable(); err != nil {
+		return err
+	}
+	tx, err := j.q.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := j.q.now()
+	res, err := tx.Exec("UPDATE simple_queue SET state=?, modified=?, error_info=? WHERE id=? AND state=?",
+		STATE_BURIED, toMillis(now), reason, j.ID, STATE_RESERVED)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotReserved
+	}
+	if err := j.q.audit(tx, eventBury, j); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	j.State = STATE_BURIED
+	j.Modified = now
+	j.ErrorInfo = reason
+	j.q.emit(eventBury, j)
+	return nil
+}
 
+// Reschedule changes when a job put with WithDelay becomes ready. It
+// returns ErrJobNotDelayed if the job is no longer delayed.
+func (j *Job) Reschedule(at time.Time) error {
+	if err := j.q.writable(); err != nil {
+		return err
+	}
+	res, err := j.q.db.Exec("UPDATE simple_queue SET ready_at=? WHERE id=? AND state=? AND ready_at IS NOT NULL",
+		toMillis(at), j.ID, STATE_DELAYED)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotDelayed
+	}
+	return nil
 }
 
 func (j *Job) Touch(ttr int) error {
+	if err := j.q.writable(); err != nil {
+		return err
+	}
 
+	ttrMillis := int64(ttr) * 1000
 	if ttr <= 0 {
-		ttr = int(j.TTR.Seconds())
+		ttrMillis = toDurationMillis(j.TTR)
 	}
 
 	tx, err := j.q.db.Begin()
+	if err != nil {
+		return err
+	}
 	defer tx.Rollback()
 
-	now := time.Now()
+	now := j.q.now()
 	j.Modified = now
 	// should we make sure job is actually reserved?
-	_, err = tx.Exec("UPDATE simple_queue SET modified=?, ttr=? WHERE id=?", now.Unix(), ttr, j.ID)
+	_, err = tx.Exec("UPDATE simple_queue SET modified=?, ttr=? WHERE id=?", toMillis(now), ttrMillis, j.ID)
 	if err != nil {
 		return err
 	}
@@ -265,54 +2228,56 @@ func (q *Queue) Tube(tube string) (*Tube, error) {
 	}, nil
 }
 
-func (t *Tube) Put(priority int, ttr int, data []byte) error {
-	return t.q.Put(t.Name, priority, ttr, data)
+func (t *Tube) Put(priority int, ttr int, data []byte, opts ...PutOption) error {
+	return t.q.Put(t.Name, priority, ttr, data, opts...)
+}
+
+// TubeWithTimeout is Tube with a default timeout in seconds for
+// ReserveDefault
+func (q *Queue) TubeWithTimeout(tube string, timeout int) (*Tube, error) {
+	t, err := q.Tube(tube)
+	if err != nil {
+		return nil, err
+	}
+	t.DefaultTimeout = timeout
+	return t, nil
 }
 
 func (t *Tube) Reserve(timeout int) (*Job, error) {
 	return t.q.Reserve(t.Name, timeout)
 }
 
-func defaultGetVersion(tx migration.LimitedTx) (int, error) {
-	v, err := getVersion(tx)
-	if err != nil {
-		if err := createVersionTable(tx); err != nil {
-			return 0, err
-		}
-		return getVersion(tx)
-	}
-	return v, nil
+// ReserveDefault is Reserve using the tube's DefaultTimeout
+func (t *Tube) ReserveDefault() (*Job, error) {
+	return t.Reserve(t.DefaultTimeout)
 }
 
-func defaultSetVersion(tx migration.LimitedTx, version int) error {
-	if err := setVersion(tx, version); err != nil {
-		if err := createVersionTable(tx); err != nil {
-			return err
-		}
-		return setVersion(tx, version)
+// Drop deletes all jobs in the tube and any index created for it by
+// CreatePartialIndex
+func (t *Tube) Drop() error {
+	if err := t.q.writable(); err != nil {
+		return err
 	}
-	return nil
-}
-
-func getVersion(tx migration.LimitedTx) (int, error) {
-	var version int
-	r := tx.QueryRow("SELECT version FROM simple_queue_version")
-	if err := r.Scan(&version); err != nil {
-		return 0, err
+	tube := t.q.tubeName(t.Name)
+	tx, err := t.q.db.Begin()
+	if err != nil {
+		return err
 	}
-	return version, nil
-}
+	defer tx.Rollback()
 
-func setVersion(tx migration.LimitedTx, version int) error {
-	_, err := tx.Exec("UPDATE simple_queue_version SET version = $1", version)
-	return err
+	if _, err := tx.Exec("DELETE FROM simple_queue WHERE tube=?", tube); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`DROP INDEX IF EXISTS "%s"`, partialIndexName(tube))); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func createVersionTable(tx migration.LimitedTx) error {
-	_, err := tx.Exec(`
-		CREATE TABLE simple_queue_version (
-			version INTEGER
-		);
-		INSERT INTO simple_queue_version (version) VALUES (0)`)
-	return err
+// DataBytes returns the total size in bytes of the data stored for the
+// jobs in the tube, after any compression
+func (t *Tube) DataBytes() (int64, error) {
+	var n int64
+	err := t.q.db.QueryRow("SELECT COALESCE(SUM(LENGTH(data)), 0) FROM simple_queue WHERE tube=?", t.q.tubeName(t.Name)).Scan(&n)
+	return n, err
 }
@@ -1,12 +1,16 @@
 package queue_test
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -14,15 +18,29 @@ import (
 	"github.com/bakins/simple-queue"
 )
 
-func withQ(t *testing.T, fn func(q *queue.Queue, t *testing.T)) {
+func withQ(t *testing.T, fn func(q *queue.Queue, t *testing.T), opts ...queue.Option) {
 	file := tempfile()
-	q, err := queue.New(file, 4, 3)
+	q, err := queue.New(file, 4, 3, opts...)
 	ok(t, err)
 	defer os.Remove(file)
 	defer q.Close()
 	fn(q, t)
 }
 
+// withDB is like withQ but also provides a separate handle on the
+// underlying database for tests that need to inspect or modify it directly.
+func withDB(t *testing.T, fn func(q *queue.Queue, db *sql.DB, t *testing.T), opts ...queue.Option) {
+	file := tempfile()
+	q, err := queue.New(file, 4, 3, opts...)
+	ok(t, err)
+	defer os.Remove(file)
+	defer q.Close()
+	db, err := sql.Open("sqlite3", file)
+	ok(t, err)
+	defer db.Close()
+	fn(q, db, t)
+}
+
 func TestNew(t *testing.T) {
 	withQ(t, func(q *queue.Queue, t *testing.T) {
 	})
@@ -35,6 +53,178 @@ func TestPut(t *testing.T) {
 	})
 }
 
+func TestPutUpsert(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.PutUpsert("test", "key", 0, 600, []byte("first")))
+		ok(t, q.PutUpsert("test", "key", 3, 600, []byte("second")))
+		ok(t, q.PutUpsert("test", "other", 0, 600, []byte("other")))
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 2, len(jobs))
+		equals(t, []byte("second"), jobs[0].Data)
+		equals(t, uint(3), jobs[0].Priority)
+		equals(t, []byte("other"), jobs[1].Data)
+	})
+}
+
+func TestPutOrUpdate(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		j, updated, err := q.PutOrUpdate("test", "key", 0, 600, []byte("first"))
+		ok(t, err)
+		assert(t, !updated, "new job reported as updated")
+		equals(t, []byte("first"), j.Data)
+
+		u, updated, err := q.PutOrUpdate("test", "key", 5, 600, []byte("second"))
+		ok(t, err)
+		assert(t, updated, "existing job not updated")
+		equals(t, j.ID, u.ID)
+		equals(t, []byte("second"), u.Data)
+		equals(t, uint(5), u.Priority)
+
+		r, err := q.Reserve("test", 0)
+		ok(t, err)
+		equals(t, j.ID, r.ID)
+		equals(t, []byte("second"), r.Data)
+		equals(t, uint(5), r.Priority)
+
+		// the job is no longer ready, so a new one is put
+		n, updated, err := q.PutOrUpdate("test", "key", 0, 600, []byte("third"))
+		ok(t, err)
+		assert(t, !updated, "reserved job was updated")
+		assert(t, n.ID != j.ID, "reserved job was reused")
+
+		ok(t, q.Close())
+		_, _, err = q.PutOrUpdate("test", "key", 0, 600, []byte("fourth"))
+		equals(t, queue.ErrClosed, err)
+	})
+}
+
+func TestPutMulti(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ids, err := q.PutMulti(context.Background(), []queue.TubeJobSpec{
+			{Tube: "a", Priority: 0, TTR: 600, Data: []byte("a")},
+			{Tube: "b", Priority: 1, TTR: 600, Data: []byte("b")},
+			{Tube: "c", Priority: 2, TTR: 600, Data: []byte("c")},
+		})
+		ok(t, err)
+		equals(t, 3, len(ids))
+
+		for i, tube := range []string{"a", "b", "c"} {
+			jobs, err := q.Jobs(tube)
+			ok(t, err)
+			equals(t, 1, len(jobs))
+			equals(t, ids[i], jobs[0].ID)
+			equals(t, []byte(tube), jobs[0].Data)
+		}
+
+		ok(t, q.Close())
+		_, err = q.PutMulti(context.Background(), []queue.TubeJobSpec{{Tube: "a", TTR: 600, Data: []byte("a")}})
+		equals(t, queue.ErrClosed, err)
+	})
+}
+
+func TestPutMultiRollback(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		_, err := q.PutMulti(context.Background(), []queue.TubeJobSpec{
+			{Tube: "a", TTR: 600, Data: []byte("a")},
+			{Tube: "b", TTR: 600, Data: []byte("b")},
+			{Tube: "c", TTR: 600, Data: []byte("c")},
+		})
+		equals(t, queue.ErrQueueFull, err)
+
+		for _, tube := range []string{"a", "b", "c"} {
+			jobs, err := q.Jobs(tube)
+			ok(t, err)
+			equals(t, 0, len(jobs))
+		}
+	}, queue.WithMaxCapacity(2))
+}
+
+func TestMaxDataSize(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("small")))
+		for i := 0; i < 3; i++ {
+			equals(t, queue.ErrJobTooLarge, q.Put("test", 0, 600, []byte("much too large")))
+		}
+		equals(t, queue.ErrJobTooLarge, q.PutUpsert("test", "key", 0, 600, []byte("much too large")))
+		equals(t, int64(4), q.Metrics().RejectedOversized)
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 1, len(jobs))
+	}, queue.WithMaxDataSize(8))
+}
+
+func TestMaxJobSize(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, make([]byte, 1024)))
+		equals(t, queue.ErrJobTooLarge, q.Put("test", 0, 600, make([]byte, 1025)))
+	}, queue.WithMaxJobSize(1024))
+}
+
+func TestMaxStoredDataSize(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, make([]byte, queue.MaxStoredDataSize)))
+		equals(t, queue.ErrJobTooLarge, q.Put("test", 0, 600, make([]byte, queue.MaxStoredDataSize+1)))
+		equals(t, queue.ErrJobTooLarge, q.PutUpsert("test", "key", 0, 600, make([]byte, queue.MaxStoredDataSize+1)))
+
+		// replacing the data is also limited
+		ok(t, q.PutUpsert("test", "key", 0, 600, []byte("small")))
+		equals(t, queue.ErrJobTooLarge, q.PutUpsert("test", "key", 0, 600, make([]byte, queue.MaxStoredDataSize+1)))
+		equals(t, int64(3), q.Metrics().RejectedOversized)
+	})
+}
+
+func TestDataCodec(t *testing.T) {
+	xor := func(data []byte) ([]byte, error) {
+		out := make([]byte, len(data))
+		for i := range data {
+			out[i] = data[i] ^ 0x5a
+		}
+		return out, nil
+	}
+
+	withDB(t, func(q *queue.Queue, db *sql.DB, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+		var stored []byte
+		ok(t, db.QueryRow("SELECT data FROM simple_queue").Scan(&stored))
+		encoded, _ := xor([]byte("testing"))
+		equals(t, encoded, stored)
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, []byte("testing"), jobs[0].Data)
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("testing"), j.Data)
+	}, queue.WithDataCodec(xor, xor))
+}
+
+func TestPutMiddleware(t *testing.T) {
+	var calls []string
+	mw := func(name string) queue.PutMiddleware {
+		return func(next queue.PutFunc) queue.PutFunc {
+			return func(ctx context.Context, tube string, priority int, ttr int, data []byte, opts ...queue.PutOption) error {
+				calls = append(calls, name)
+				return next(ctx, tube, priority, ttr, append(data, name...), opts...)
+			}
+		}
+	}
+
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("data-")))
+		equals(t, []string{"a", "b"}, calls)
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		equals(t, []byte("data-ab"), j.Data)
+	}, queue.WithPutMiddleware(mw("a"), mw("b")))
+}
+
 func TestReserve(t *testing.T) {
 	withQ(t, func(q *queue.Queue, t *testing.T) {
 		err := q.Put("test", 0, 600, []byte("testing"))
@@ -46,6 +236,196 @@ func TestReserve(t *testing.T) {
 	})
 }
 
+func TestReserveIf(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+		var calls int
+		ready := false
+		readyFn := func() bool {
+			calls++
+			return ready
+		}
+
+		start := time.Now()
+		j, err := q.ReserveIf(context.Background(), "test", readyFn)
+		equals(t, queue.ErrCircuitOpen, err)
+		assert(t, j == nil, "job is not nil")
+		assert(t, time.Since(start) < time.Second, "ReserveIf blocked")
+		equals(t, 1, calls)
+
+		ready = true
+		j, err = q.ReserveIf(context.Background(), "test", readyFn)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("testing"), j.Data)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		j, err = q.ReserveIf(ctx, "test", readyFn)
+		equals(t, context.DeadlineExceeded, err)
+		assert(t, j == nil, "job is not nil")
+	})
+}
+
+func TestReserveOrderSameSecond(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 50; i++ {
+			ok(t, q.Put("test", 0, 600, []byte(fmt.Sprint(i))))
+		}
+		for i := 0; i < 50; i++ {
+			j, err := q.Reserve("test", 0)
+			ok(t, err)
+			assert(t, j != nil, "job is nil")
+			equals(t, []byte(fmt.Sprint(i)), j.Data)
+		}
+	}, queue.WithClock(clock.Now))
+}
+
+func TestNotify(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		ids, err := q.Notify(ctx, "test")
+		ok(t, err)
+
+		ok(t, q.Put("other", 0, 600, []byte("other")))
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+		var id int
+		select {
+		case id = <-ids:
+		case <-time.After(time.Second):
+			t.Fatal("no notification")
+		}
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, id, j.ID)
+
+		cancel()
+		select {
+		case _, open := <-ids:
+			assert(t, !open, "unexpected notification")
+		case <-time.After(time.Second):
+			t.Fatal("channel not closed")
+		}
+	})
+}
+
+func TestConcurrentPutOrdering(t *testing.T) {
+	// every job is created at the same time, so only seq orders them
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		var wg sync.WaitGroup
+		errs := make(chan error, 4)
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for n := 0; n < 25; n++ {
+					if err := q.Put("test", 0, 600, []byte(fmt.Sprintf("%d-%d", g, n))); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			ok(t, err)
+		}
+
+		last := int64(0)
+		next := make(map[int]int)
+		for i := 0; i < 100; i++ {
+			j, err := q.Reserve("test", 0)
+			ok(t, err)
+			assert(t, j != nil, "job is nil")
+			assert(t, j.Seq > last, "seq %d reserved after %d", j.Seq, last)
+			last = j.Seq
+
+			// each goroutine's jobs are reserved in the order it put them
+			var g, n int
+			_, err = fmt.Sscanf(string(j.Data), "%d-%d", &g, &n)
+			ok(t, err)
+			equals(t, next[g], n)
+			next[g]++
+		}
+	}, queue.WithClock(clock.Now))
+}
+
+func TestSubSecondOrdering(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		clock.Advance(500 * time.Millisecond)
+		ok(t, q.Put("test", 0, 600, []byte("later")))
+		clock.Advance(-400 * time.Millisecond)
+		ok(t, q.Put("test", 0, 600, []byte("earlier")))
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("earlier"), j.Data)
+		equals(t, clock.Now(), j.Created)
+	}, queue.WithClock(clock.Now))
+}
+
+func TestJobAge(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		clock.Advance(90 * time.Second)
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		equals(t, 90*time.Second, j.Age())
+
+		clock.Advance(time.Second)
+		equals(t, 91*time.Second, j.Age())
+	}, queue.WithClock(clock.Now))
+}
+
+func TestDetachedJobAge(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		j, err := q.FirstReady("test")
+		ok(t, err)
+		data, err := j.MarshalBinary()
+		ok(t, err)
+
+		var detached queue.Job
+		ok(t, detached.UnmarshalBinary(data))
+		age := detached.Age()
+		assert(t, age >= 0 && age < time.Minute, "unexpected age %s", age)
+	})
+}
+
+func TestMillisecondTTR(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing"), queue.WithTTR(500*time.Millisecond)))
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, 500*time.Millisecond, j.TTR)
+
+		clock.Advance(400 * time.Millisecond)
+		ok(t, q.Maintanence())
+		j, err = q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j == nil, "job is not nil")
+
+		clock.Advance(200 * time.Millisecond)
+		ok(t, q.Maintanence())
+		j, err = q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+	}, queue.WithClock(clock.Now))
+}
+
 func TestJobs(t *testing.T) {
 	withQ(t, func(q *queue.Queue, t *testing.T) {
 		err := q.Put("test", 0, 600, []byte("testing"))
@@ -139,15 +519,1383 @@ func TestJobTouch(t *testing.T) {
 	})
 }
 
-func sleep(delay int) {
-	time.Sleep(time.Duration(delay) * time.Second)
+func TestTTL(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		err := q.Put("test", 0, 600, []byte("testing"), queue.WithTTL(time.Second))
+		ok(t, err)
+		err = q.Put("test", 0, 600, []byte("forever"))
+		ok(t, err)
+
+		clock.Advance(2 * time.Second)
+		ok(t, q.Maintanence())
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 1, len(jobs))
+		equals(t, []byte("forever"), jobs[0].Data)
+		equals(t, int64(1), q.Metrics().JobsExpired)
+	}, queue.WithClock(clock.Now))
 }
 
-func tempfile() string {
-	f, _ := ioutil.TempFile("", "queue-")
-	f.Close()
-	os.Remove(f.Name())
-	return f.Name()
+func TestPutWithExpiry(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		equals(t, queue.ErrExpiryInPast, q.PutWithExpiry("test", 0, 600, clock.Now().Add(-time.Second), []byte("late")))
+
+		ok(t, q.PutWithExpiry("soon", 0, 600, clock.Now().Add(time.Second), []byte("soon")))
+		ok(t, q.PutWithExpiry("later", 0, 600, clock.Now().AddDate(1, 0, 0), []byte("later")))
+
+		for i := 0; i < 3; i++ {
+			clock.Advance(2 * time.Second)
+			ok(t, q.Maintanence())
+		}
+
+		jobs, err := q.Jobs("soon")
+		ok(t, err)
+		equals(t, 0, len(jobs))
+		jobs, err = q.Jobs("later")
+		ok(t, err)
+		equals(t, 1, len(jobs))
+	}, queue.WithClock(clock.Now))
+}
+
+func TestReserveLatency(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		clock.Advance(3 * time.Second)
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		assert(t, j.Latency >= 3*time.Second, "latency too small: %s", j.Latency)
+	}, queue.WithClock(clock.Now))
+}
+
+func TestReserveCount(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+		var j *queue.Job
+		for i := 1; i <= 5; i++ {
+			var err error
+			j, err = q.Reserve("test", 0)
+			ok(t, err)
+			assert(t, j != nil, "job is nil")
+			equals(t, i, j.ReserveCount)
+			ok(t, j.Release())
+		}
+		equals(t, queue.ErrJobNotReserved, j.Release())
+
+		jobs, err := q.FrequentlyReserved("test", 5)
+		ok(t, err)
+		equals(t, 1, len(jobs))
+		equals(t, 5, jobs[0].ReserveCount)
+
+		jobs, err = q.FrequentlyReserved("test", 6)
+		ok(t, err)
+		equals(t, 0, len(jobs))
+	})
+}
+
+func TestAttempts(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+		for i := 0; i < 3; i++ {
+			j, err := q.Reserve("test", 0)
+			ok(t, err)
+			assert(t, j != nil, "job is nil")
+			equals(t, i, j.Attempts)
+			ok(t, j.Release())
+		}
+	})
+}
+
+func TestSharedCache(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+
+	for _, open := range []func() (*queue.Queue, error){
+		func() (*queue.Queue, error) { return queue.New("file:"+file+"?cache=shared", 4, 3) },
+		func() (*queue.Queue, error) { return queue.New(file, 4, 3, queue.WithSharedCache()) },
+	} {
+		a, err := open()
+		ok(t, err)
+		b, err := open()
+		ok(t, err)
+
+		ok(t, a.Put("test", 0, 600, []byte("testing")))
+		j, err := b.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("testing"), j.Data)
+
+		a.Close()
+		b.Close()
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("one")))
+		ok(t, q.Put("test", 0, 600, []byte("two")))
+
+		ro, err := queue.OpenReadOnly(q.Filename())
+		ok(t, err)
+		defer ro.Close()
+
+		jobs, err := ro.Jobs("test")
+		ok(t, err)
+		equals(t, 2, len(jobs))
+
+		stats, err := ro.TubeStats("test")
+		ok(t, err)
+		equals(t, int64(2), stats.Ready)
+
+		equals(t, queue.ErrReadOnly, ro.Put("test", 0, 600, []byte("three")))
+		equals(t, queue.ErrReadOnly, ro.PutUpsert("test", "key", 0, 600, []byte("three")))
+		_, err = ro.PutMulti(context.Background(), []queue.TubeJobSpec{{Tube: "test", TTR: 600, Data: []byte("three")}})
+		equals(t, queue.ErrReadOnly, err)
+		_, err = ro.Reserve("test", 0)
+		equals(t, queue.ErrReadOnly, err)
+		equals(t, queue.ErrReadOnly, jobs[0].Delete())
+		equals(t, queue.ErrReadOnly, jobs[0].Release())
+		equals(t, queue.ErrReadOnly, jobs[0].Bury("reason"))
+		equals(t, queue.ErrReadOnly, jobs[0].Touch(0))
+		equals(t, queue.ErrReadOnly, ro.Maintanence())
+		equals(t, queue.ErrReadOnly, ro.PutRecurring("test", "@hourly", 0, 600, []byte("three")))
+		equals(t, queue.ErrReadOnly, ro.SwapPriority(jobs[0].ID, jobs[1].ID))
+		equals(t, queue.ErrReadOnly, ro.CreatePartialIndex("test"))
+		equals(t, queue.ErrReadOnly, ro.GC())
+		_, err = ro.ResolveDependencies()
+		equals(t, queue.ErrReadOnly, err)
+		_, err = ro.ReplayLog(tempfile())
+		equals(t, queue.ErrReadOnly, err)
+		_, err = ro.PurgeAll()
+		equals(t, queue.ErrReadOnly, err)
+		tube, err := ro.Tube("test")
+		ok(t, err)
+		equals(t, queue.ErrReadOnly, tube.Drop())
+
+		// nothing was changed
+		jobs, err = q.Jobs("test")
+		ok(t, err)
+		equals(t, 2, len(jobs))
+	})
+
+	_, err := queue.OpenReadOnly(tempfile())
+	assert(t, err != nil, "expected error opening missing file")
+}
+
+func TestReserveAs(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("one")))
+		ok(t, q.Put("test", 0, 600, []byte("two")))
+
+		j, err := q.ReserveAs("test", "worker-1", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, "worker-1", j.Worker)
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		owners := make(map[int]string)
+		for _, job := range jobs {
+			owners[job.ID] = job.Worker
+		}
+		equals(t, 2, len(owners))
+		equals(t, "worker-1", owners[j.ID])
+
+		ok(t, j.Release())
+		equals(t, "", j.Worker)
+		jobs, err = q.Jobs("test")
+		ok(t, err)
+		for _, job := range jobs {
+			equals(t, "", job.Worker)
+		}
+	})
+}
+
+func TestJobsByTrace(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("a", 0, 600, []byte("a1"), queue.WithTraceID("trace-1")))
+		ok(t, q.Put("b", 0, 600, []byte("b1"), queue.WithTraceID("trace-2")))
+		ok(t, q.Put("b", 0, 600, []byte("b2"), queue.WithTraceID("trace-1")))
+		ok(t, q.Put("a", 0, 600, []byte("untraced")))
+
+		jobs, err := q.JobsByTrace("trace-1")
+		ok(t, err)
+		equals(t, 2, len(jobs))
+		equals(t, []byte("a1"), jobs[0].Data)
+		equals(t, []byte("b2"), jobs[1].Data)
+		equals(t, "trace-1", jobs[1].TraceID)
+
+		j, err := q.Reserve("b", 0)
+		ok(t, err)
+		equals(t, "trace-2", j.TraceID)
+
+		jobs, err = q.JobsByTrace("none")
+		ok(t, err)
+		equals(t, 0, len(jobs))
+	})
+}
+
+func TestJobsByWorker(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 4; i++ {
+			ok(t, q.Put("test", 0, 600, []byte(fmt.Sprint(i))))
+		}
+
+		held := make(map[int]bool)
+		for i := 0; i < 3; i++ {
+			j, err := q.ReserveAs("test", "worker-1", 0)
+			ok(t, err)
+			held[j.ID] = true
+		}
+		_, err := q.ReserveAs("test", "worker-2", 0)
+		ok(t, err)
+
+		jobs, err := q.JobsByWorker("worker-1")
+		ok(t, err)
+		equals(t, 3, len(jobs))
+		for _, j := range jobs {
+			assert(t, held[j.ID], "job %d not held by worker-1", j.ID)
+			equals(t, "worker-1", j.Worker)
+		}
+
+		jobs, err = q.JobsByWorker("worker-3")
+		ok(t, err)
+		equals(t, 0, len(jobs))
+	})
+}
+
+func TestMaxInFlightPerWorker(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 4; i++ {
+			ok(t, q.Put("test", 0, 600, []byte(fmt.Sprint(i))))
+		}
+
+		var held []*queue.Job
+		for i := 0; i < 2; i++ {
+			j, err := q.ReserveAs("test", "worker-1", 0)
+			ok(t, err)
+			assert(t, j != nil, "job is nil")
+			held = append(held, j)
+		}
+
+		_, err := q.ReserveAs("test", "worker-1", 0)
+		equals(t, queue.ErrTooManyInFlight, err)
+
+		// other workers are not affected
+		j, err := q.ReserveAs("test", "worker-2", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+
+		ok(t, held[0].Delete())
+		j, err = q.ReserveAs("test", "worker-1", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+	}, queue.WithMaxInFlightPerWorker(2))
+}
+
+func TestReadyOrder(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		j, err := q.FirstReady("test")
+		ok(t, err)
+		assert(t, j == nil, "job is not nil")
+
+		for _, p := range []int{5, 1, 10, 1, 3} {
+			ok(t, q.Put("test", p, 600, []byte(fmt.Sprint(p))))
+		}
+
+		first, err := q.FirstReady("test")
+		ok(t, err)
+		equals(t, uint(10), first.Priority)
+
+		last, err := q.LastReady("test")
+		ok(t, err)
+		equals(t, uint(1), last.Priority)
+		// the newest of the lowest priority jobs is last
+		equals(t, first.ID+1, last.ID)
+
+		second, err := q.NthReady("test", 2)
+		ok(t, err)
+		equals(t, uint(5), second.Priority)
+
+		fifth, err := q.NthReady("test", 5)
+		ok(t, err)
+		equals(t, last.ID, fifth.ID)
+
+		sixth, err := q.NthReady("test", 6)
+		ok(t, err)
+		assert(t, sixth == nil, "job is not nil")
+
+		// nothing was reserved
+		stats, err := q.TubeStats("test")
+		ok(t, err)
+		equals(t, int64(5), stats.Ready)
+	})
+}
+
+func TestPutLevel(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.PutLevel("test", queue.PriorityLow, 600, []byte("low")))
+		ok(t, q.PutLevel("test", queue.PriorityNormal, 600, []byte("normal")))
+		ok(t, q.PutLevel("test", queue.PriorityCritical, 600, []byte("critical")))
+		ok(t, q.PutLevel("test", queue.PriorityHigh, 600, []byte("high")))
+
+		for _, exp := range []string{"critical", "high", "normal", "low"} {
+			j, err := q.Reserve("test", 0)
+			ok(t, err)
+			equals(t, []byte(exp), j.Data)
+			equals(t, exp, queue.Priority(j.Priority).String())
+		}
+	})
+}
+
+func TestPutFloat(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.PutFloat("test", 10.001, 600, []byte("b")))
+		ok(t, q.Put("test", 10, 600, []byte("c")))
+		ok(t, q.PutFloat("test", 10.002, 600, []byte("a")))
+		ok(t, q.PutFloat("test", 9.999, 600, []byte("d")))
+		assert(t, q.PutFloat("test", -1, 600, []byte("e")) != nil, "expected error for negative priority")
+
+		for _, exp := range []string{"a", "b", "c", "d"} {
+			j, err := q.Reserve("test", 0)
+			ok(t, err)
+			equals(t, []byte(exp), j.Data)
+		}
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 10.002, jobs[0].PriorityFloat)
+		equals(t, uint(10), jobs[0].Priority)
+	})
+}
+
+func TestDeleteIfReserved(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+		stale, err := q.Reserve("test", 0)
+		ok(t, err)
+		ok(t, q.ForceExpire(stale.ID))
+		equals(t, queue.ErrJobNotReserved, stale.DeleteIfReserved())
+
+		// another worker now holds the job
+		current, err := q.ReserveAs("test", "other", 0)
+		ok(t, err)
+		equals(t, stale.ID, current.ID)
+		equals(t, queue.ErrJobNotReserved, stale.DeleteIfReserved())
+		exists, err := q.JobExists(current.ID)
+		ok(t, err)
+		equals(t, true, exists)
+
+		ok(t, current.Touch(0))
+		ok(t, current.DeleteIfReserved())
+		exists, err = q.JobExists(current.ID)
+		ok(t, err)
+		equals(t, false, exists)
+	})
+}
+
+func TestJobExists(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		j, err := q.FirstReady("test")
+		ok(t, err)
+
+		exists, err := q.JobExists(j.ID)
+		ok(t, err)
+		equals(t, true, exists)
+
+		ok(t, j.Delete())
+		exists, err = q.JobExists(j.ID)
+		ok(t, err)
+		equals(t, false, exists)
+
+		exists, err = q.JobExists(j.ID + 100)
+		ok(t, err)
+		equals(t, false, exists)
+	})
+}
+
+func TestTryReserve(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		_, err := q.TryReserve("test", 0)
+		equals(t, queue.ErrEmpty, err)
+
+		start := time.Now()
+		_, err = q.TryReserve("test", 1)
+		equals(t, queue.ErrTimeout, err)
+		assert(t, time.Since(start) >= time.Second, "returned before the timeout")
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			q.Put("other", 0, 600, []byte("other"))
+			time.Sleep(100 * time.Millisecond)
+			q.Put("test", 0, 600, []byte("testing"))
+		}()
+		j, err := q.TryReserve("test", 2)
+		ok(t, err)
+		equals(t, []byte("testing"), j.Data)
+	})
+}
+
+func TestMultiReserve(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 5; i++ {
+			ok(t, q.Put("test", i, 600, []byte(fmt.Sprint(i))))
+		}
+
+		_, err := q.MultiReserve("test", -1, 0)
+		assert(t, err != nil, "expected error for negative count")
+		jobs, err := q.MultiReserve("test", 0, 0)
+		ok(t, err)
+		equals(t, 0, len(jobs))
+
+		jobs, err = q.MultiReserve("test", 10, 0)
+		ok(t, err)
+		equals(t, 5, len(jobs))
+		for i, j := range jobs {
+			equals(t, queue.STATE_RESERVED, j.State)
+			equals(t, []byte(fmt.Sprint(4-i)), j.Data)
+		}
+
+		all, err := q.Jobs("test")
+		ok(t, err)
+		for _, j := range all {
+			equals(t, queue.STATE_RESERVED, j.State)
+		}
+
+		jobs, err = q.MultiReserve("test", 10, 0)
+		ok(t, err)
+		equals(t, 0, len(jobs))
+	})
+}
+
+func TestBury(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("bad")))
+		ok(t, q.Put("test", 0, 600, []byte("silent")))
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		equals(t, "", j.ErrorInfo)
+		ok(t, j.Bury("parse error: unexpected EOF"))
+		equals(t, queue.STATE_BURIED, j.State)
+		equals(t, queue.ErrJobNotReserved, j.Bury("again"))
+
+		j2, err := q.Reserve("test", 0)
+		ok(t, err)
+		ok(t, j2.Bury(""))
+
+		// buried jobs are not reserved
+		j3, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j3 == nil, "job is not nil")
+
+		jobs, err := q.BuriedWithErrors("test")
+		ok(t, err)
+		equals(t, 1, len(jobs))
+		equals(t, j.ID, jobs[0].ID)
+		equals(t, "parse error: unexpected EOF", jobs[0].ErrorInfo)
+
+		found, err := q.JobByID(j.ID)
+		ok(t, err)
+		equals(t, queue.STATE_BURIED, found.State)
+		equals(t, "parse error: unexpected EOF", found.ErrorInfo)
+
+		found, err = q.JobByID(j.ID + 100)
+		ok(t, err)
+		assert(t, found == nil, "job is not nil")
+	})
+}
+
+func TestBuriedRetention(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		ok(t, j.Bury("failed"))
+
+		n, err := q.BuriedCount()
+		ok(t, err)
+		equals(t, 1, n)
+
+		clock.Advance(time.Hour)
+		ok(t, q.Maintanence())
+		n, err = q.BuriedCount()
+		ok(t, err)
+		equals(t, 1, n)
+
+		clock.Advance(time.Second)
+		ok(t, q.Maintanence())
+		n, err = q.BuriedCount()
+		ok(t, err)
+		equals(t, 0, n)
+	}, queue.WithClock(clock.Now), queue.WithBuriedRetention(time.Hour))
+}
+
+func TestNextPerTube(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("a", 0, 600, []byte("a-low")))
+		ok(t, q.Put("a", 5, 600, []byte("a-high")))
+		ok(t, q.Put("b", 0, 600, []byte("b")))
+		ok(t, q.Put("c", 0, 600, []byte("c")))
+
+		next, err := q.NextPerTube()
+		ok(t, err)
+		equals(t, 3, len(next))
+		equals(t, []byte("a-high"), next["a"].Data)
+		equals(t, []byte("b"), next["b"].Data)
+		equals(t, []byte("c"), next["c"].Data)
+
+		j, err := q.Reserve("c", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+
+		next, err = q.NextPerTube()
+		ok(t, err)
+		equals(t, 2, len(next))
+		assert(t, next["c"] == nil, "reserved job returned")
+	})
+}
+
+func TestPutAfter(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("parent")))
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		parent := jobs[0]
+
+		ok(t, q.PutAfter("test", parent.ID, 10, 600, []byte("child")))
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("parent"), j.Data)
+
+		n, err := q.ResolveDependencies()
+		ok(t, err)
+		equals(t, int64(0), n)
+
+		j, err = q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j == nil, "job is not nil")
+
+		ok(t, parent.Delete())
+		n, err = q.ResolveDependencies()
+		ok(t, err)
+		equals(t, int64(1), n)
+
+		j, err = q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("child"), j.Data)
+	})
+}
+
+func TestResolvedDependencyWakesReserve(t *testing.T) {
+	for _, resolve := range []func(q *queue.Queue) error{
+		func(q *queue.Queue) error {
+			_, err := q.ResolveDependencies()
+			return err
+		},
+		(*queue.Queue).Maintanence,
+	} {
+		withQ(t, func(q *queue.Queue, t *testing.T) {
+			ok(t, q.Put("test", 0, 600, []byte("parent")))
+			parent, err := q.Reserve("test", 1)
+			ok(t, err)
+			ok(t, q.PutAfter("test", parent.ID, 0, 600, []byte("child")))
+
+			reserved := make(chan *queue.Job, 1)
+			errs := make(chan error, 1)
+			go func() {
+				j, err := q.Reserve("test", 5)
+				errs <- err
+				reserved <- j
+			}()
+
+			start := time.Now()
+			ok(t, parent.Delete())
+			ok(t, resolve(q))
+			ok(t, <-errs)
+			j := <-reserved
+			assert(t, j != nil, "waiting reserve got no job")
+			equals(t, []byte("child"), j.Data)
+			assert(t, time.Since(start) < 2*time.Second, "reserve was not woken")
+		})
+	}
+}
+
+func TestDelayedJobs(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("cancelled"), queue.WithDelay(time.Minute)))
+		ok(t, q.Put("test", 0, 600, []byte("later"), queue.WithDelay(2*time.Minute)))
+
+		jobs, err := q.DelayedJobs("test")
+		ok(t, err)
+		equals(t, 2, len(jobs))
+		equals(t, []byte("cancelled"), jobs[0].Data)
+		equals(t, queue.STATE_DELAYED, jobs[0].State)
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j == nil, "delayed job was reserved")
+
+		ok(t, jobs[0].Delete())
+
+		clock.Advance(2 * time.Minute)
+		ok(t, q.Maintanence())
+
+		jobs, err = q.DelayedJobs("test")
+		ok(t, err)
+		equals(t, 0, len(jobs))
+
+		j, err = q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("later"), j.Data)
+
+		j, err = q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j == nil, "cancelled job was reserved")
+	}, queue.WithClock(clock.Now))
+}
+
+func TestReschedule(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing"), queue.WithDelay(time.Hour)))
+		jobs, err := q.DelayedJobs("test")
+		ok(t, err)
+		equals(t, 1, len(jobs))
+
+		ok(t, jobs[0].Reschedule(clock.Now().Add(time.Minute)))
+
+		clock.Advance(30 * time.Second)
+		ok(t, q.Maintanence())
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j == nil, "job reserved before its new time")
+
+		clock.Advance(30 * time.Second)
+		ok(t, q.Maintanence())
+		j, err = q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, jobs[0].ID, j.ID)
+
+		equals(t, queue.ErrJobNotDelayed, j.Reschedule(clock.Now()))
+	}, queue.WithClock(clock.Now))
+}
+
+func TestFlush(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 1, []byte("testing")))
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+
+		reserved := make(chan *queue.Job, 1)
+		errs := make(chan error, 1)
+		go func() {
+			j, err := q.Reserve("test", 5)
+			errs <- err
+			reserved <- j
+		}()
+
+		clock.Advance(2 * time.Second)
+		ok(t, q.Flush())
+
+		ok(t, <-errs)
+		again := <-reserved
+		assert(t, again != nil, "expired job was not reclaimed")
+		equals(t, j.ID, again.ID)
+	}, queue.WithClock(clock.Now), queue.WithWALMode())
+}
+
+func TestWakeAll(t *testing.T) {
+	withDB(t, func(q *queue.Queue, db *sql.DB, t *testing.T) {
+		equals(t, 0, q.WakeAll())
+
+		const waiters = 4
+		reserved := make(chan *queue.Job, waiters)
+		errs := make(chan error, waiters)
+		for i := 0; i < waiters; i++ {
+			go func() {
+				j, err := q.Reserve("test", 5)
+				errs <- err
+				reserved <- j
+			}()
+		}
+
+		// jobs added behind the queue's back wake no one
+		for i := 0; i < 6; i++ {
+			_, err := db.Exec("INSERT INTO simple_queue (tube, created, modified, state, data, ttr, priority, seq) VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
+				"test", time.Now().UnixNano()/int64(time.Millisecond), 0, queue.STATE_READY, []byte("testing"), 600000, 0, i+1)
+			ok(t, err)
+		}
+		// the wait buffer holds 4
+		equals(t, waiters, q.WakeAll())
+
+		seen := make(map[int]bool)
+		for i := 0; i < waiters; i++ {
+			ok(t, <-errs)
+			j := <-reserved
+			assert(t, j != nil, "waiter %d got no job", i)
+			assert(t, !seen[j.ID], "job %d reserved twice", j.ID)
+			seen[j.ID] = true
+		}
+	})
+}
+
+func TestSetMaintenanceInterval(t *testing.T) {
+	file := tempfile()
+	q, err := queue.New(file, 4, 60)
+	ok(t, err)
+	defer os.Remove(file)
+	defer q.Close()
+
+	assert(t, q.SetMaintenanceInterval(time.Millisecond) != nil, "expected error for short interval")
+
+	ok(t, q.Put("test", 0, 600, []byte("testing"), queue.WithTTL(time.Millisecond)))
+	ok(t, q.SetMaintenanceInterval(time.Second))
+
+	// the expired job is only removed by maintenance
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		if len(jobs) == 0 {
+			break
+		}
+		assert(t, time.Now().Before(deadline), "maintenance did not run")
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	ok(t, q.Close())
+	equals(t, queue.ErrClosed, q.SetMaintenanceInterval(time.Second))
+}
+
+func TestForceExpire(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		var o countingObserver
+		q.AddObserver(&o)
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+		j, err := q.ReserveAs("test", "crashed", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, queue.ErrJobNotReserved, q.ForceExpire(j.ID+1))
+
+		ok(t, q.ForceExpire(j.ID))
+		equals(t, queue.ErrJobNotReserved, q.ForceExpire(j.ID))
+		equals(t, 1, o.Counts()["expire"])
+
+		again, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, again != nil, "job was not made ready")
+		equals(t, j.ID, again.ID)
+		equals(t, "", again.Worker)
+	})
+}
+
+func TestMaxDBSize(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		data := bytes.Repeat([]byte("x"), 4096)
+		var err error
+		for i := 0; i < 100 && err == nil; i++ {
+			err = q.Put("test", 0, 600, data)
+		}
+		equals(t, queue.ErrQueueFull, err)
+
+		// deleted jobs still take up space until the file is compacted
+		_, err = q.PurgeAll()
+		ok(t, err)
+		equals(t, queue.ErrQueueFull, q.Put("test", 0, 600, data))
+
+		ok(t, q.Vacuum())
+		ok(t, q.Put("test", 0, 600, data))
+	}, queue.WithMaxDBSize(128*1024))
+}
+
+func TestDeferredClose(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("before")))
+		closed := q.DeferredClose(100 * time.Millisecond)
+
+		// the queue works until it is closed
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+
+		select {
+		case err := <-closed:
+			ok(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("queue was not closed")
+		}
+		equals(t, queue.ErrClosed, q.Put("test", 0, 600, []byte("after")))
+		_, err = q.Reserve("test", 0)
+		equals(t, queue.ErrClosed, err)
+	})
+}
+
+func TestAudit(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now().UnixNano() / int64(time.Millisecond)
+	withDB(t, func(q *queue.Queue, db *sql.DB, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("deleted")))
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		clock.Advance(time.Second)
+		ok(t, j.Delete())
+
+		ok(t, q.Put("other", 0, 600, []byte("buried")))
+		b, err := q.Reserve("other", 0)
+		ok(t, err)
+		ok(t, b.Bury("failed"))
+
+		rows, err := db.Query("SELECT job_id, tube, event, created FROM simple_queue_audit ORDER BY id")
+		ok(t, err)
+		defer rows.Close()
+		var events []string
+		for rows.Next() {
+			var id, created int64
+			var tube, event string
+			ok(t, rows.Scan(&id, &tube, &event, &created))
+			events = append(events, fmt.Sprintf("%d %s %s %d", id, tube, event, created-start))
+		}
+		ok(t, rows.Err())
+		equals(t, []string{
+			fmt.Sprintf("%d test put 0", j.ID),
+			fmt.Sprintf("%d test reserve 0", j.ID),
+			fmt.Sprintf("%d test delete 1000", j.ID),
+			fmt.Sprintf("%d other put 1000", b.ID),
+			fmt.Sprintf("%d other reserve 1000", b.ID),
+			fmt.Sprintf("%d other bury 1000", b.ID),
+		}, events)
+	}, queue.WithClock(clock.Now), queue.WithAudit())
+}
+
+func TestAuditFailure(t *testing.T) {
+	withDB(t, func(q *queue.Queue, db *sql.DB, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+
+		// a change is not made without its audit row
+		_, err = db.Exec("DROP TABLE simple_queue_audit")
+		ok(t, err)
+		assert(t, q.Put("test", 0, 600, []byte("unaudited")) != nil, "expected put to fail")
+		assert(t, j.Delete() != nil, "expected delete to fail")
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 1, len(jobs))
+		equals(t, queue.STATE_RESERVED, jobs[0].State)
+	}, queue.WithAudit())
+}
+
+func TestCompactDeleted(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+	q, err := queue.New(file, 4, 3)
+	ok(t, err)
+	defer q.Close()
+
+	fileSize := func() int64 {
+		fi, err := os.Stat(file)
+		ok(t, err)
+		return fi.Size()
+	}
+
+	// the first compaction converts the database, later ones are incremental
+	for i := 0; i < 2; i++ {
+		specs := make([]queue.TubeJobSpec, 200)
+		for i := range specs {
+			specs[i] = queue.TubeJobSpec{Tube: "test", TTR: 600, Data: bytes.Repeat([]byte("x"), 4096)}
+		}
+		_, err := q.PutMulti(context.Background(), specs)
+		ok(t, err)
+		full := fileSize()
+
+		_, err = q.PurgeAll()
+		ok(t, err)
+		equals(t, full, fileSize())
+
+		saved, err := q.CompactDeleted()
+		ok(t, err)
+		assert(t, saved > 800*1024, "only saved %d bytes", saved)
+		assert(t, fileSize() < full-800*1024, "file did not shrink from %d: %d", full, fileSize())
+	}
+}
+
+func TestCloseUnblocksReserve(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		errs := make(chan error, 1)
+		go func() {
+			_, err := q.Reserve("test", 30)
+			errs <- err
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+		ok(t, q.Close())
+
+		select {
+		case err := <-errs:
+			equals(t, queue.ErrClosed, err)
+		case <-time.After(time.Second):
+			t.Fatal("reserve did not return after close")
+		}
+
+		_, err := q.Reserve("test", 0)
+		equals(t, queue.ErrClosed, err)
+	})
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		ok(t, q.CheckIntegrity())
+	})
+}
+
+func TestPutBurst(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		// more puts than the wait buffer must not block
+		for i := 0; i < 100; i++ {
+			ok(t, q.Put("test", 0, 600, []byte("testing")))
+		}
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 100, len(jobs))
+	})
+}
+
+func TestPutWakesReserve(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		// a burst of puts followed by reservers draining the coalesced signals
+		for i := 0; i < 10; i++ {
+			ok(t, q.Put("other", 0, 600, []byte("testing")))
+		}
+		for i := 0; i < 4; i++ {
+			j, err := q.Reserve("other", 1)
+			ok(t, err)
+			assert(t, j != nil, "job is nil")
+		}
+
+		jobs := make(chan *queue.Job, 1)
+		go func() {
+			j, err := q.Reserve("test", 10)
+			ok(t, err)
+			jobs <- j
+		}()
+		time.Sleep(100 * time.Millisecond)
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+		select {
+		case j := <-jobs:
+			assert(t, j != nil, "job is nil")
+		case <-time.After(2 * time.Second):
+			t.Fatal("reserve was not woken by put")
+		}
+	})
+}
+
+func BenchmarkPutBurst(b *testing.B) {
+	file := tempfile()
+	q, err := queue.New(file, 4, 3)
+	ok(b, err)
+	defer os.Remove(file)
+	defer q.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ok(b, q.Put("test", 0, 600, []byte("testing")))
+	}
+}
+
+func TestSwapPriority(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 1, 600, []byte("low")))
+		ok(t, q.Put("test", 5, 600, []byte("high")))
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 2, len(jobs))
+		ok(t, q.SwapPriority(jobs[0].ID, jobs[1].ID))
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("low"), j.Data)
+		equals(t, uint(5), j.Priority)
+
+		err = q.SwapPriority(jobs[0].ID, jobs[1].ID)
+		equals(t, queue.ErrJobNotReady, err)
+	})
+}
+
+func TestExpiryDeadLetterTube(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 1, []byte("testing")))
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+
+		clock.Advance(2 * time.Second)
+		ok(t, q.Maintanence())
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 0, len(jobs))
+
+		dead, err := q.Reserve("dead", 0)
+		ok(t, err)
+		assert(t, dead != nil, "job is nil")
+		equals(t, j.ID, dead.ID)
+		equals(t, "dead", dead.Tube)
+	}, queue.WithClock(clock.Now), queue.WithExpiryDeadLetterTube("dead"))
+}
+
+func TestReclaim(t *testing.T) {
+	withDB(t, func(q *queue.Queue, db *sql.DB, t *testing.T) {
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		for _, ttr := range []int{0, -5, 86400*365*1000 + 1} {
+			_, err := db.Exec("INSERT into simple_queue (tube, created, modified, state, data, ttr, priority) VALUES(?, ?, ?, ?, ?, ?, ?)",
+				"test", now, now, queue.STATE_RESERVED, []byte("stuck"), ttr, 0)
+			ok(t, err)
+		}
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+
+		n, err := q.Reclaim()
+		ok(t, err)
+		equals(t, int64(3), n)
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 1, len(jobs))
+		equals(t, j.ID, jobs[0].ID)
+	})
+}
+
+func TestPurgeAll(t *testing.T) {
+	withDB(t, func(q *queue.Queue, db *sql.DB, t *testing.T) {
+		for _, tube := range []string{"a", "b", "c"} {
+			ok(t, q.Put(tube, 0, 600, []byte("testing")))
+			ok(t, q.Put(tube, 0, 600, []byte("testing")))
+		}
+		_, err := q.Reserve("a", 0)
+		ok(t, err)
+
+		n, err := q.PurgeAll()
+		ok(t, err)
+		equals(t, 6, n)
+
+		var count int
+		ok(t, db.QueryRow("SELECT COUNT(*) FROM simple_queue").Scan(&count))
+		equals(t, 0, count)
+
+		// the table is still usable
+		ok(t, q.Put("a", 0, 600, []byte("again")))
+		j, err := q.Reserve("a", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 10; i++ {
+			ok(t, q.Put("test", 0, 600, []byte(fmt.Sprint(i))))
+			clock.Advance(time.Second)
+		}
+		ok(t, q.Put("other", 0, 600, []byte("other")))
+
+		n, err := q.Truncate("test", 20)
+		ok(t, err)
+		equals(t, int64(0), n)
+
+		n, err = q.Truncate("test", 3)
+		ok(t, err)
+		equals(t, int64(7), n)
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 3, len(jobs))
+		for i, j := range jobs {
+			equals(t, []byte(fmt.Sprint(7+i)), j.Data)
+		}
+
+		n, err = q.Truncate("test", 0)
+		ok(t, err)
+		equals(t, int64(3), n)
+
+		jobs, err = q.Jobs("other")
+		ok(t, err)
+		equals(t, 1, len(jobs))
+	}, queue.WithClock(clock.Now))
+}
+
+func TestReserveAt(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 5; i++ {
+			ok(t, q.Put("test", 0, 600, []byte(fmt.Sprint(i))))
+			clock.Advance(time.Second)
+		}
+
+		j, err := q.ReserveAt("test", 2, 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("2"), j.Data)
+		equals(t, queue.STATE_RESERVED, j.State)
+
+		// the reserved job no longer counts towards the offset
+		j, err = q.ReserveAt("test", 2, 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("3"), j.Data)
+
+		j, err = q.ReserveAt("test", 3, 0)
+		ok(t, err)
+		assert(t, j == nil, "job is not nil")
+	}, queue.WithClock(clock.Now))
+}
+
+func TestRedistribute(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 100; i++ {
+			ok(t, q.Put("A", 0, 600, []byte(fmt.Sprint(i))))
+		}
+
+		n, err := q.Redistribute([]string{"A"}, []string{"B", "C"})
+		ok(t, err)
+
+		counts := make(map[string]int)
+		for _, tube := range []string{"A", "B", "C"} {
+			jobs, err := q.Jobs(tube)
+			ok(t, err)
+			counts[tube] = len(jobs)
+			assert(t, len(jobs) >= 32 && len(jobs) <= 35, "tube %s has %d jobs", tube, len(jobs))
+		}
+		equals(t, int64(counts["B"]+counts["C"]), n)
+
+		// the jobs that would be reserved first stay in A
+		j, err := q.Reserve("A", 0)
+		ok(t, err)
+		equals(t, []byte("0"), j.Data)
+
+		n, err = q.Redistribute([]string{"A"}, []string{"B", "C"})
+		ok(t, err)
+		equals(t, int64(0), n)
+	})
+}
+
+func TestTubeReserveDefault(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		tube, err := q.TubeWithTimeout("test", 1)
+		ok(t, err)
+		equals(t, 1, tube.DefaultTimeout)
+
+		start := time.Now()
+		j, err := tube.ReserveDefault()
+		ok(t, err)
+		assert(t, j == nil, "expected no job")
+		assert(t, time.Since(start) >= time.Second, "returned after %s", time.Since(start))
+
+		ok(t, tube.Put(0, 600, []byte("testing")))
+		j, err = tube.ReserveDefault()
+		ok(t, err)
+		equals(t, []byte("testing"), j.Data)
+	})
+}
+
+func TestTubeDataBytes(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		tube, err := q.Tube("test")
+		ok(t, err)
+		n, err := tube.DataBytes()
+		ok(t, err)
+		equals(t, int64(0), n)
+
+		ok(t, tube.Put(0, 600, []byte("12345")))
+		ok(t, tube.Put(0, 600, bytes.Repeat([]byte{0}, 1000)))
+		ok(t, q.Put("other", 0, 600, []byte("other")))
+
+		n, err = tube.DataBytes()
+		ok(t, err)
+		equals(t, int64(1005), n)
+	})
+}
+
+func TestJobsByPriorityRange(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for _, p := range []int{0, 20, 100, 10, 50} {
+			ok(t, q.Put("test", p, 600, []byte(fmt.Sprint(p))))
+		}
+		ok(t, q.Put("other", 20, 600, []byte("other")))
+
+		jobs, err := q.JobsByPriorityRange("test", 10, 50, queue.STATE_READY)
+		ok(t, err)
+		var priorities []uint
+		for _, j := range jobs {
+			priorities = append(priorities, j.Priority)
+		}
+		equals(t, []uint{50, 20, 10}, priorities)
+
+		jobs, err = q.JobsByPriorityRange("test", 10, 50, queue.STATE_RESERVED)
+		ok(t, err)
+		equals(t, 0, len(jobs))
+
+		_, err = q.JobsByPriorityRange("test", 50, 10, queue.STATE_READY)
+		assert(t, err != nil, "expected error for inverted range")
+	})
+}
+
+func TestMaintenanceBatches(t *testing.T) {
+	var log lockedBuffer
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		specs := make([]queue.TubeJobSpec, 2000)
+		for i := range specs {
+			specs[i] = queue.TubeJobSpec{Tube: "test", TTR: 1, Data: []byte("testing")}
+		}
+		_, err := q.PutMulti(context.Background(), specs)
+		ok(t, err)
+		jobs, err := q.MultiReserve("test", len(specs), 0)
+		ok(t, err)
+		equals(t, len(specs), len(jobs))
+
+		log.Take()
+		clock.Advance(2 * time.Second)
+		ok(t, q.Maintanence())
+
+		var batches []string
+		for _, l := range strings.Split(log.Take(), "\n") {
+			if strings.Contains(l, `query="UPDATE simple_queue SET state=?, worker=NULL WHERE id IN`) {
+				batches = append(batches, l[strings.Index(l, " rows="):strings.Index(l, " duration=")])
+			}
+		}
+		equals(t, []string{" rows=500", " rows=500", " rows=500", " rows=500", " rows=0"}, batches)
+
+		stats, err := q.TubeStats("test")
+		ok(t, err)
+		equals(t, int64(len(specs)), stats.Ready)
+	}, queue.WithClock(clock.Now), queue.WithMaintenanceBatchSize(500), queue.WithDebugSQL(&log))
+}
+
+func TestRotate(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		j, err := q.Rotate("test")
+		ok(t, err)
+		assert(t, j == nil, "job is not nil")
+
+		for i := 0; i < 4; i++ {
+			ok(t, q.Put("test", 0, 600, []byte(fmt.Sprint(i))))
+		}
+		ok(t, q.Put("test", 10, 600, []byte("high")))
+
+		high, err := q.Reserve("test", 0)
+		ok(t, err)
+		equals(t, []byte("high"), high.Data)
+
+		// the clock has not moved, so only the sequence orders the rotated job
+		j, err = q.Rotate("test")
+		ok(t, err)
+		equals(t, []byte("0"), j.Data)
+		equals(t, queue.STATE_READY, j.State)
+
+		var order []string
+		for {
+			j, err := q.Reserve("test", 0)
+			ok(t, err)
+			if j == nil {
+				break
+			}
+			order = append(order, string(j.Data))
+		}
+		equals(t, []string{"1", "2", "3", "0"}, order)
+	}, queue.WithClock(clock.Now))
+}
+
+func TestShrink(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 20; i++ {
+			// priorities 0 to 19 in a shuffled order
+			ok(t, q.Put("test", (i*7)%20, 600, []byte(fmt.Sprint(i))))
+		}
+		ok(t, q.Put("other", 0, 600, []byte("other")))
+		held, err := q.Reserve("test", 0)
+		ok(t, err)
+		equals(t, uint(19), held.Priority)
+
+		n, err := q.Shrink("test", 5)
+		ok(t, err)
+		equals(t, int64(14), n)
+
+		var kept []uint
+		for {
+			j, err := q.Reserve("test", 0)
+			ok(t, err)
+			if j == nil {
+				break
+			}
+			kept = append(kept, j.Priority)
+		}
+		equals(t, []uint{18, 17, 16, 15, 14}, kept)
+
+		jobs, err := q.Jobs("other")
+		ok(t, err)
+		equals(t, 1, len(jobs))
+	})
+}
+
+func sleep(delay int) {
+	time.Sleep(time.Duration(delay) * time.Second)
+}
+
+func tempfile() string {
+	f, _ := ioutil.TempFile("", "queue-")
+	f.Close()
+	os.Remove(f.Name())
+	return f.Name()
+}
+
+type fakeClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1415000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
 }
 
 // Thanks to https://github.com/benbjohnson/testing
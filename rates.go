@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultRateWindowSize is the number of events remembered per tube and
+// operation if Options.RateWindowSize is not set
+const defaultRateWindowSize = 1024
+
+// rateOps are the operations RateOf can measure
+var rateOps = map[string]bool{
+	eventPut:     true,
+	eventReserve: true,
+	eventDelete:  true,
+}
+
+// rateKey identifies the events of one operation on a tube
+type rateKey struct {
+	tube string
+	op   string
+}
+
+// rateRing holds the times of the most recent events, in Unix
+// nanoseconds. It grows to the window size as events are recorded, so
+// quiet tubes use little memory.
+type rateRing struct {
+	times []int64
+	next  int
+}
+
+// rates remembers recent events for RateOf
+type rates struct {
+	sync.Mutex
+	size  int
+	rings map[rateKey]*rateRing
+}
+
+// record remembers an event at t, if it is one RateOf measures
+func (r *rates) record(op, tube string, t time.Time) {
+	if !rateOps[op] {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.rings == nil {
+		r.rings = make(map[rateKey]*rateRing)
+	}
+	k := rateKey{tube: tube, op: op}
+	ring := r.rings[k]
+	if ring == nil {
+		ring = &rateRing{}
+		r.rings[k] = ring
+	}
+	if len(ring.times) < r.size {
+		ring.times = append(ring.times, t.UnixNano())
+		return
+	}
+	ring.times[ring.next] = t.UnixNano()
+	ring.next = (ring.next + 1) % r.size
+}
+
+// RateOf returns the number of op events per second in tube, where op is
+// "put", "reserve" or "delete". The rate is taken over the time spanned by
+// the remembered events that happened within the window ending now, so a
+// burst of 10 puts in one second is a rate of 10 whatever the window. If
+// fewer than two events fall in the window the rate is their number
+// divided by the window. Events are remembered in memory, so only those
+// made through this Queue are counted, and at most RateWindowSize events
+// are remembered per tube and op.
+func (q *Queue) RateOf(tube string, op string, window time.Duration) (float64, error) {
+	if !rateOps[op] {
+		return 0, fmt.Errorf("unknown op: %s", op)
+	}
+	if window <= 0 {
+		return 0, fmt.Errorf("invalid window: %s", window)
+	}
+	now := q.now().UnixNano()
+	since := now - int64(window)
+
+	r := &q.rates
+	r.Lock()
+	defer r.Unlock()
+	ring := r.rings[rateKey{tube: q.tubeName(tube), op: op}]
+	if ring == nil {
+		return 0, nil
+	}
+	var n int
+	first, last := now, since
+	for _, t := range ring.times {
+		if t <= since || t > now {
+			continue
+		}
+		n++
+		if t < first {
+			first = t
+		}
+		if t > last {
+			last = t
+		}
+	}
+	if n < 2 || last == first {
+		return float64(n) / window.Seconds(), nil
+	}
+	return float64(n-1) / time.Duration(last-first).Seconds(), nil
+}
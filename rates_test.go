@@ -0,0 +1,64 @@
+package queue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestRateOf(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		// 10 puts in one second
+		for i := 0; i < 10; i++ {
+			ok(t, q.Put("test", 0, 600, []byte("testing")))
+			clock.Advance(100 * time.Millisecond)
+		}
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		ok(t, j.Delete())
+
+		rate, err := q.RateOf("test", "put", 5*time.Second)
+		ok(t, err)
+		equals(t, 10.0, rate)
+		// a single event is divided by the window
+		rate, err = q.RateOf("test", "delete", 5*time.Second)
+		ok(t, err)
+		equals(t, 0.2, rate)
+		rate, err = q.RateOf("other", "put", 5*time.Second)
+		ok(t, err)
+		equals(t, 0.0, rate)
+		_, err = q.RateOf("test", "bury", 5*time.Second)
+		assert(t, err != nil, "expected error for unknown op")
+
+		// events outside the window are not counted
+		clock.Advance(10 * time.Second)
+		rate, err = q.RateOf("test", "put", 5*time.Second)
+		ok(t, err)
+		equals(t, 0.0, rate)
+		rate, err = q.RateOf("test", "put", time.Minute)
+		ok(t, err)
+		equals(t, 10.0, rate)
+	}, queue.WithClock(clock.Now))
+}
+
+func TestRateWindowSize(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 5; i++ {
+			ok(t, q.Put("test", 0, 600, []byte("testing")))
+			clock.Advance(time.Second)
+		}
+		for i := 0; i < 4; i++ {
+			ok(t, q.Put("test", 0, 600, []byte("testing")))
+			clock.Advance(100 * time.Millisecond)
+		}
+
+		// only the last four puts are remembered, so the slow puts
+		// before them are forgotten
+		rate, err := q.RateOf("test", "put", time.Minute)
+		ok(t, err)
+		equals(t, 10.0, rate)
+	}, queue.WithClock(clock.Now), queue.WithRateWindowSize(4))
+}
@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// Reaper periodically deletes old audit entries for jobs that no longer
+// exist. Create one with NewReaper.
+type Reaper struct {
+	q        *Queue
+	maxAge   time.Duration
+	interval time.Duration
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+
+	lock  sync.Mutex
+	stats ReaperStats
+}
+
+// ReaperStats describes the work done by a Reaper
+type ReaperStats struct {
+	TotalDeleted    int64
+	LastRunAt       time.Time
+	LastDeleteCount int64
+}
+
+// NewReaper starts deleting audit entries older than maxAge whose job has
+// been deleted, checking every interval. It stops when Stop is called or
+// the queue is closed.
+func NewReaper(q *Queue, maxAge time.Duration, interval time.Duration) *Reaper {
+	r := &Reaper{
+		q:        q,
+		maxAge:   maxAge,
+		interval: interval,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *Reaper) run() {
+	defer close(r.stopped)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.q.exit:
+			return
+		case <-ticker.C:
+			r.reap()
+		}
+	}
+}
+
+// reap deletes the old entries once
+func (r *Reaper) reap() error {
+	now := r.q.now()
+	res, err := r.q.db.Exec(`DELETE FROM simple_queue_audit WHERE created < ?
+                             AND job_id NOT IN (SELECT id FROM simple_queue)`, toMillis(now.Add(-r.maxAge)))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.stats.TotalDeleted += n
+	r.stats.LastRunAt = now
+	r.stats.LastDeleteCount = n
+	return nil
+}
+
+// Stats returns what the reaper has done so far
+func (r *Reaper) Stats() ReaperStats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.stats
+}
+
+// Stop stops the reaper and waits for it to finish
+func (r *Reaper) Stop() {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	<-r.stopped
+}
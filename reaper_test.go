@@ -0,0 +1,51 @@
+package queue_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestReaper(t *testing.T) {
+	clock := newFakeClock()
+	withDB(t, func(q *queue.Queue, db *sql.DB, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		live := jobs[0].ID
+
+		old := clock.Now().Add(-2*time.Hour).UnixNano() / int64(time.Millisecond)
+		recent := clock.Now().UnixNano() / int64(time.Millisecond)
+		for _, e := range []struct {
+			job     int
+			created int64
+		}{
+			{live + 100, old},    // deleted job, old entry
+			{live + 100, recent}, // deleted job, new entry
+			{live, old},          // job still exists
+		} {
+			_, err := db.Exec("INSERT into simple_queue_audit (job_id, tube, event, created) VALUES(?, ?, ?, ?)",
+				e.job, "test", "put", e.created)
+			ok(t, err)
+		}
+
+		r := queue.NewReaper(q, time.Hour, 10*time.Millisecond)
+		defer r.Stop()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for r.Stats().TotalDeleted == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		r.Stop()
+
+		stats := r.Stats()
+		equals(t, int64(1), stats.TotalDeleted)
+		equals(t, clock.Now(), stats.LastRunAt)
+
+		var remaining int
+		ok(t, db.QueryRow("SELECT COUNT(*) FROM simple_queue_audit").Scan(&remaining))
+		equals(t, 2, remaining)
+	}, queue.WithClock(clock.Now))
+}
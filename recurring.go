@@ -0,0 +1,270 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule computes when a recurring job is next due
+type schedule interface {
+	// next returns the first time after t that the job is due
+	next(t time.Time) time.Time
+}
+
+// PutRecurring adds a template that maintenance uses to put a new ready
+// job in tube each time spec is due. spec is either "@every <duration>",
+// such as "@every 30s", one of "@hourly", "@daily", "@weekly", "@monthly"
+// or "@yearly", or a cron expression with five fields: minute, hour, day
+// of month, month and day of week. If maintenance falls behind, missed
+// runs are skipped and only one job is put.
+func (q *Queue) PutRecurring(tube string, spec string, priority, ttr int, data []byte) error {
+	if err := q.writable(); err != nil {
+		return err
+	}
+	s, err := parseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	tube = q.tubeName(tube)
+	// the jobs put would fail maintenance when logged
+	if q.opLog != nil && len(tube) > opLogTubeSize {
+		return errTubeTooLong
+	}
+	stored, err := q.encode(data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT into simple_queue_recurring (tube, spec, priority, ttr, data, next_run) VALUES(?, ?, ?, ?, ?, ?)",
+		tube, spec, priority, int64(ttr)*1000, stored, toMillis(s.next(q.now())))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// putRecurring puts a job for each recurring template that is due and
+// schedules its next run. The jobs put are returned so they can be
+// signalled once tx is committed.
+func (q *Queue) putRecurring(tx *sql.Tx) ([]*Job, error) {
+	now := q.now()
+	rows, err := tx.Query("SELECT id, tube, spec, priority, ttr, data FROM simple_queue_recurring WHERE next_run <= ?", toMillis(now))
+	if err != nil {
+		return nil, err
+	}
+
+	type recurrence struct {
+		id, priority int
+		tube, spec   string
+		ttr          int64
+		data         []byte
+	}
+	var due []recurrence
+	for rows.Next() {
+		var r recurrence
+		if err := rows.Scan(&r.id, &r.tube, &r.spec, &r.priority, &r.ttr, &r.data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(due))
+	for _, r := range due {
+		s, err := parseSchedule(r.spec)
+		if err != nil {
+			return nil, err
+		}
+		data, err := q.decode(r.data)
+		if err != nil {
+			return nil, err
+		}
+		// a full queue skips this run rather than failing maintenance
+		j, err := q.insert(tx, r.tube, r.priority, 0, data, putOptions{ttr: fromDurationMillis(r.ttr)})
+		switch err {
+		case nil:
+			jobs = append(jobs, j)
+		case ErrQueueFull:
+		default:
+			return nil, err
+		}
+
+		if _, err := tx.Exec("UPDATE simple_queue_recurring SET next_run=? WHERE id=?", toMillis(s.next(now)), r.id); err != nil {
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+// everySchedule is due at a fixed interval
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// cronSchedule is due when the time matches every field. Each field is a
+// bit set of the values that match.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are set if the field was "*". As in cron, if
+	// both day fields are restricted a day matching either is due.
+	domStar, dowStar bool
+}
+
+var cronShortcuts = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// parseSchedule parses a spec as described by PutRecurring
+func parseSchedule(spec string) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q: interval must be positive", spec)
+		}
+		return everySchedule{interval: d}, nil
+	}
+	if s, ok := cronShortcuts[spec]; ok {
+		spec = s
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields", spec)
+	}
+
+	var s cronSchedule
+	var err error
+	ranges := []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, r := range ranges {
+		if *r.bits, err = parseCronField(fields[i], r.min, r.max); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+		}
+	}
+	// sunday may be written as 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+	if s.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: never due", spec)
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and
+// steps, such as "1,5-10,*/15", into a bit set
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			lo = n
+			if step == 1 {
+				hi = n
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the zero time if the schedule is never due, such as on the
+// 31st of February
+func (s cronSchedule) next(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	// a schedule that has not matched in five years never will
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
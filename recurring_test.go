@@ -0,0 +1,84 @@
+package queue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestPutRecurring(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.PutRecurring("test", "@every 1s", 0, 600, []byte("tick")))
+
+		count := func() int {
+			ok(t, q.Maintanence())
+			jobs, err := q.Jobs("test")
+			ok(t, err)
+			return len(jobs)
+		}
+
+		equals(t, 0, count())
+		clock.Advance(time.Second)
+		equals(t, 1, count())
+		clock.Advance(500 * time.Millisecond)
+		equals(t, 1, count())
+		clock.Advance(500 * time.Millisecond)
+		equals(t, 2, count())
+
+		// missed runs are not made up
+		clock.Advance(10 * time.Second)
+		equals(t, 3, count())
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("tick"), j.Data)
+		equals(t, 600*time.Second, j.TTR)
+	}, queue.WithClock(clock.Now))
+}
+
+func TestPutRecurringCron(t *testing.T) {
+	// the clock starts at 07:33:20 UTC
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.PutRecurring("test", "*/5 * * * *", 0, 600, []byte("tick")))
+
+		count := func() int {
+			ok(t, q.Maintanence())
+			jobs, err := q.Jobs("test")
+			ok(t, err)
+			return len(jobs)
+		}
+
+		clock.Advance(99 * time.Second)
+		equals(t, 0, count())
+		clock.Advance(time.Second)
+		equals(t, 1, count())
+		clock.Advance(4 * time.Minute)
+		equals(t, 1, count())
+		clock.Advance(time.Minute)
+		equals(t, 2, count())
+	}, queue.WithClock(func() time.Time { return clock.Now().UTC() }))
+}
+
+func TestPutRecurringInvalid(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for _, spec := range []string{
+			"",
+			"@every",
+			"@every -1s",
+			"@sometimes",
+			"* * * *",
+			"60 * * * *",
+			"* * 0 * *",
+			"*/0 * * * *",
+			"a * * * *",
+			"0 0 31 2 *",
+		} {
+			err := q.PutRecurring("test", spec, 0, 600, []byte("tick"))
+			assert(t, err != nil, "expected error for %q", spec)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrReservationDone is returned by Commit and Abort once the reservation
+// has been committed or aborted
+var ErrReservationDone = errors.New("reservation already finished")
+
+// Reservation is a job held for a two phase operation, such as one that
+// must happen atomically with a write to another system. Once the other
+// system's transaction is decided, the reservation is either committed,
+// deleting the job, or aborted, returning it to its tube.
+type Reservation struct {
+	Job *Job
+
+	lock sync.Mutex
+	done bool
+}
+
+// Acquire reserves a job from tube like Reserve and returns it as a
+// Reservation. It returns nil if no job is ready within timeout seconds.
+func (q *Queue) Acquire(tube string, timeout int) (*Reservation, error) {
+	j, err := q.Reserve(tube, timeout)
+	if err != nil || j == nil {
+		return nil, err
+	}
+	return &Reservation{Job: j}, nil
+}
+
+// Commit deletes the job. It returns ErrJobNotReserved if the reservation
+// expired first, in which case the job may be given to another worker.
+func (r *Reservation) Commit() error {
+	return r.finish(func(j *Job) error {
+		if err := j.q.writable(); err != nil {
+			return err
+		}
+		tx, err := j.q.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec("DELETE from simple_queue WHERE id=? AND state=?", j.ID, STATE_RESERVED)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrJobNotReserved
+		}
+		if err := j.q.audit(tx, eventDelete, j); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		j.q.throughput.record(j.q.now())
+		j.q.emit(eventDelete, j)
+		return nil
+	})
+}
+
+// Abort returns the job to its tube so it can be reserved again
+func (r *Reservation) Abort() error {
+	return r.finish((*Job).Release)
+}
+
+// finish calls fn with the job unless the reservation is already finished
+func (r *Reservation) finish(fn func(*Job) error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.done {
+		return ErrReservationDone
+	}
+	if err := fn(r.Job); err != nil {
+		return err
+	}
+	r.done = true
+	return nil
+}
@@ -0,0 +1,38 @@
+package queue_test
+
+import (
+	"testing"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestReservation(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+		r, err := q.Acquire("test", 0)
+		ok(t, err)
+		assert(t, r != nil, "reservation is nil")
+		equals(t, []byte("testing"), r.Job.Data)
+
+		none, err := q.Acquire("test", 0)
+		ok(t, err)
+		assert(t, none == nil, "job acquired twice")
+
+		ok(t, r.Abort())
+		equals(t, queue.ErrReservationDone, r.Commit())
+
+		r, err = q.Acquire("test", 0)
+		ok(t, err)
+		assert(t, r != nil, "aborted job was not returned to the tube")
+		ok(t, r.Commit())
+		equals(t, queue.ErrReservationDone, r.Abort())
+
+		j, err := q.JobByID(r.Job.ID)
+		ok(t, err)
+		assert(t, j == nil, "committed job was not deleted")
+		r, err = q.Acquire("test", 0)
+		ok(t, err)
+		assert(t, r == nil, "committed job was acquired")
+	})
+}
@@ -0,0 +1,400 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/BurntSushi/migration"
+)
+
+// schemaMigration is a schema change along with a check for whether it
+// has been applied
+type schemaMigration struct {
+	migrate migration.Migrator
+	// applied reports whether the objects created by migrate exist
+	applied func(tx migration.LimitedTx) (bool, error)
+}
+
+// migrations are applied in order when a queue is opened
+var migrations = []schemaMigration{
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`
+               CREATE table simple_queue (
+                 id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+                 tube text NOT NULL,
+                 priority INTEGERT DEFAULT 0,
+                 created INTEGER NOT NULL,
+                 modified INTEGER NOT NULL,
+                 state INTEGER NOT NULL,
+                 data text NOT NULL,
+                 ttr INTEGER NOT NULL
+               )`)
+			return err
+		},
+		applied: hasTable("simple_queue"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`CREATE INDEX simple_queue_tube_idx ON simple_queue(tube)`)
+			return err
+		},
+		applied: hasIndex("simple_queue_tube_idx"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`ALTER TABLE simple_queue ADD COLUMN ttl INTEGER NOT NULL DEFAULT 0`)
+			return err
+		},
+		applied: hasColumn("simple_queue", "ttl"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			if _, err := tx.Exec(`ALTER TABLE simple_queue ADD COLUMN dedup_key text`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE INDEX simple_queue_dedup_key_idx ON simple_queue(tube, dedup_key)`)
+			return err
+		},
+		applied: hasIndex("simple_queue_dedup_key_idx"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`ALTER TABLE simple_queue ADD COLUMN reserve_count INTEGER NOT NULL DEFAULT 0`)
+			return err
+		},
+		applied: hasColumn("simple_queue", "reserve_count"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`ALTER TABLE simple_queue ADD COLUMN depends_on INTEGER`)
+			return err
+		},
+		applied: hasColumn("simple_queue", "depends_on"),
+	},
+	{
+		migrate: migrateMillis,
+		applied: dataMigration,
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`
+               CREATE table simple_queue_recurring (
+                 id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+                 tube text NOT NULL,
+                 spec text NOT NULL,
+                 priority INTEGER NOT NULL DEFAULT 0,
+                 ttr INTEGER NOT NULL,
+                 data text NOT NULL,
+                 next_run INTEGER NOT NULL
+               )`)
+			return err
+		},
+		applied: hasTable("simple_queue_recurring"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`ALTER TABLE simple_queue ADD COLUMN error_info text`)
+			return err
+		},
+		applied: hasColumn("simple_queue", "error_info"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`ALTER TABLE simple_queue ADD COLUMN worker text`)
+			return err
+		},
+		applied: hasColumn("simple_queue", "worker"),
+	},
+	{
+		// SQLite cannot add a CHECK constraint to an existing table, so
+		// the size limit is enforced by triggers
+		migrate: func(tx migration.LimitedTx) error {
+			for _, trigger := range []string{
+				"simple_queue_data_size_insert BEFORE INSERT",
+				"simple_queue_data_size_update BEFORE UPDATE OF data",
+			} {
+				_, err := tx.Exec(fmt.Sprintf(`
+                  CREATE TRIGGER %s ON simple_queue
+                  WHEN length(CAST(NEW.data AS BLOB)) > %d
+                  BEGIN SELECT RAISE(ABORT, 'job too large'); END`, trigger, MaxStoredDataSize))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		applied: hasTrigger("simple_queue_data_size_update"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			if _, err := tx.Exec(`
+               CREATE table simple_queue_audit (
+                 id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+                 job_id INTEGER NOT NULL,
+                 tube text NOT NULL,
+                 event text NOT NULL,
+                 created INTEGER NOT NULL
+               )`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE INDEX simple_queue_audit_job_idx ON simple_queue_audit(job_id)`)
+			return err
+		},
+		applied: hasIndex("simple_queue_audit_job_idx"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			if _, err := tx.Exec(`ALTER TABLE simple_queue ADD COLUMN seq INTEGER NOT NULL DEFAULT 0`); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`UPDATE simple_queue SET seq = id`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE INDEX simple_queue_seq_idx ON simple_queue(seq)`)
+			return err
+		},
+		applied: hasIndex("simple_queue_seq_idx"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`CREATE INDEX simple_queue_reserve_idx ON simple_queue(tube, state, priority DESC, created, seq, id)`)
+			return err
+		},
+		applied: hasIndex("simple_queue_reserve_idx"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`ALTER TABLE simple_queue ADD COLUMN ready_at INTEGER`)
+			return err
+		},
+		applied: hasColumn("simple_queue", "ready_at"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`
+               CREATE table simple_queue_tubes (
+                 tube text NOT NULL PRIMARY KEY,
+                 max_capacity INTEGER NOT NULL DEFAULT 0,
+                 default_ttr INTEGER NOT NULL DEFAULT 0,
+                 default_priority INTEGER NOT NULL DEFAULT 0
+               )`)
+			return err
+		},
+		applied: hasTable("simple_queue_tubes"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`ALTER TABLE simple_queue_tubes ADD COLUMN max_in_flight INTEGER NOT NULL DEFAULT 0`)
+			return err
+		},
+		applied: hasColumn("simple_queue_tubes", "max_in_flight"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			if _, err := tx.Exec(`
+               CREATE table simple_queue_leader (
+                 id INTEGER NOT NULL PRIMARY KEY CHECK (id = 1),
+                 owner text NOT NULL,
+                 expires INTEGER NOT NULL
+               )`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT INTO simple_queue_leader (id, owner, expires) VALUES (1, '', 0)`)
+			return err
+		},
+		applied: hasTable("simple_queue_leader"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`ALTER TABLE simple_queue ADD COLUMN metadata TEXT`)
+			return err
+		},
+		applied: hasColumn("simple_queue", "metadata"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`ALTER TABLE simple_queue_tubes ADD COLUMN ordering TEXT NOT NULL DEFAULT ''`)
+			return err
+		},
+		applied: hasColumn("simple_queue_tubes", "ordering"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`ALTER TABLE simple_queue ADD COLUMN trace_id TEXT`)
+			return err
+		},
+		applied: hasColumn("simple_queue", "trace_id"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`CREATE INDEX simple_queue_trace_id_idx ON simple_queue(trace_id)`)
+			return err
+		},
+		applied: hasIndex("simple_queue_trace_id_idx"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`ALTER TABLE simple_queue ADD COLUMN put_key TEXT`)
+			return err
+		},
+		applied: hasColumn("simple_queue", "put_key"),
+	},
+	{
+		migrate: func(tx migration.LimitedTx) error {
+			_, err := tx.Exec(`CREATE UNIQUE INDEX simple_queue_put_key_idx ON simple_queue(put_key)`)
+			return err
+		},
+		applied: hasIndex("simple_queue_put_key_idx"),
+	},
+}
+
+func migrators() []migration.Migrator {
+	m := make([]migration.Migrator, len(migrations))
+	for i := range migrations {
+		m[i] = migrations[i].migrate
+	}
+	return m
+}
+
+// GC checks which migrations have actually been applied and resets the
+// schema version to the last one that was fully applied. This recovers
+// from a migration that failed partway. Any migrations after that version
+// are applied the next time the queue is opened.
+func (q *Queue) GC() error {
+	if err := q.writable(); err != nil {
+		return err
+	}
+	tx, err := q.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	version := 0
+	for _, m := range migrations {
+		ok, err := m.applied(tx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		version++
+	}
+
+	if err := defaultSetVersion(tx, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateMillis converts timestamps and durations stored in seconds to
+// milliseconds. Durations can't be told apart by size, so they are only
+// converted in rows whose created time is still in seconds. This keeps the
+// migration safe to run again, such as after GC.
+func migrateMillis(tx migration.LimitedTx) error {
+	// every expression sees the values from before the update
+	_, err := tx.Exec(`UPDATE simple_queue SET
+                 created = CASE WHEN created < ? THEN created * 1000 ELSE created END,
+                 modified = CASE WHEN modified < ? THEN modified * 1000 ELSE modified END,
+                 ttr = CASE WHEN created < ? THEN ttr * 1000 ELSE ttr END,
+                 ttl = CASE WHEN created < ? THEN ttl * 1000 ELSE ttl END`,
+		secondsCutoff, secondsCutoff, secondsCutoff, secondsCutoff)
+	return err
+}
+
+// dataMigration is the applied check for migrations that only change data
+// and so leave nothing to look for. They are assumed to have been applied.
+func dataMigration(tx migration.LimitedTx) (bool, error) {
+	return true, nil
+}
+
+func hasTable(name string) func(migration.LimitedTx) (bool, error) {
+	return hasSchemaObject("table", name)
+}
+
+func hasIndex(name string) func(migration.LimitedTx) (bool, error) {
+	return hasSchemaObject("index", name)
+}
+
+func hasTrigger(name string) func(migration.LimitedTx) (bool, error) {
+	return hasSchemaObject("trigger", name)
+}
+
+func hasSchemaObject(kind, name string) func(migration.LimitedTx) (bool, error) {
+	return func(tx migration.LimitedTx) (bool, error) {
+		var exists bool
+		err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type=? AND name=?)", kind, name).Scan(&exists)
+		return exists, err
+	}
+}
+
+func hasColumn(table, column string) func(migration.LimitedTx) (bool, error) {
+	return func(tx migration.LimitedTx) (bool, error) {
+		rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+		if err != nil {
+			return false, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				cid, notnull, pk int
+				name, typ        string
+				dflt             sql.NullString
+			)
+			if err := rows.Scan(&cid, &name, &typ, &notnull, &dflt, &pk); err != nil {
+				return false, err
+			}
+			if name == column {
+				return true, nil
+			}
+		}
+		return false, rows.Err()
+	}
+}
+
+func defaultGetVersion(tx migration.LimitedTx) (int, error) {
+	v, err := getVersion(tx)
+	if err != nil {
+		if err := createVersionTable(tx); err != nil {
+			return 0, err
+		}
+		return getVersion(tx)
+	}
+	return v, nil
+}
+
+func defaultSetVersion(tx migration.LimitedTx, version int) error {
+	if err := setVersion(tx, version); err != nil {
+		if err := createVersionTable(tx); err != nil {
+			return err
+		}
+		return setVersion(tx, version)
+	}
+	return nil
+}
+
+func getVersion(tx migration.LimitedTx) (int, error) {
+	var version int
+	r := tx.QueryRow("SELECT version FROM simple_queue_version")
+	if err := r.Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func setVersion(tx migration.LimitedTx, version int) error {
+	_, err := tx.Exec("UPDATE simple_queue_version SET version = $1", version)
+	return err
+}
+
+func createVersionTable(tx migration.LimitedTx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE simple_queue_version (
+			version INTEGER
+		);
+		INSERT INTO simple_queue_version (version) VALUES (0)`)
+	return err
+}
@@ -0,0 +1,81 @@
+package queue_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestGC(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+
+	q, err := queue.New(file, 4, 3)
+	ok(t, err)
+
+	db, err := sql.Open("sqlite3", file)
+	ok(t, err)
+	defer db.Close()
+
+	var version int
+	ok(t, db.QueryRow("SELECT version FROM simple_queue_version").Scan(&version))
+	_, err = db.Exec("UPDATE simple_queue_version SET version=?", version+1)
+	ok(t, err)
+	ok(t, q.Close())
+
+	q, err = queue.New(file, 4, 3)
+	ok(t, err)
+	defer q.Close()
+
+	ok(t, q.GC())
+
+	var got int
+	ok(t, db.QueryRow("SELECT version FROM simple_queue_version").Scan(&got))
+	equals(t, version, got)
+}
+
+func TestSecondTimestamps(t *testing.T) {
+	withDB(t, func(q *queue.Queue, db *sql.DB, t *testing.T) {
+		// rows written by older versions store seconds
+		created := time.Unix(1415000000, 0)
+		_, err := db.Exec("INSERT into simple_queue (tube, created, modified, state, data, ttr, priority) VALUES(?, ?, ?, ?, ?, ?, ?)",
+			"test", created.Unix(), created.Unix(), queue.STATE_READY, []byte("old"), 600000, 0)
+		ok(t, err)
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 1, len(jobs))
+		equals(t, created, jobs[0].Created)
+		equals(t, created, jobs[0].Modified)
+	})
+}
+
+func TestMigrateMillisAgain(t *testing.T) {
+	withDB(t, func(q *queue.Queue, db *sql.DB, t *testing.T) {
+		created := time.Unix(1415000000, 0)
+		_, err := db.Exec("INSERT into simple_queue (tube, created, modified, state, data, ttr, ttl, priority) VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
+			"test", created.Unix(), created.Unix(), queue.STATE_READY, []byte("old"), 600, 60, 0)
+		ok(t, err)
+		ok(t, q.Put("test", 0, 600, []byte("new"), queue.WithTTL(time.Minute)))
+
+		// running the migration again leaves converted rows alone
+		for i := 0; i < 2; i++ {
+			tx, err := db.Begin()
+			ok(t, err)
+			ok(t, queue.MigrateMillis(tx))
+			ok(t, tx.Commit())
+		}
+
+		jobs, err := q.Jobs("test")
+		ok(t, err)
+		equals(t, 2, len(jobs))
+		for _, j := range jobs {
+			equals(t, 600*time.Second, j.TTR)
+			equals(t, time.Minute, j.TTL)
+		}
+		equals(t, created, jobs[0].Created)
+	})
+}
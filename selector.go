@@ -0,0 +1,37 @@
+package queue
+
+// Selector chooses which ready job Reserve takes from a tube
+type Selector interface {
+	// Select returns an optional condition, ANDed with the tube and
+	// state, and an ordering for the ready jobs in tube, along with any
+	// arguments for placeholders in the condition and ordering. The first
+	// job in the ordering is reserved.
+	Select(tube string) (where string, orderBy string, args []interface{})
+}
+
+// DefaultSelector reserves jobs by highest priority, then oldest
+type DefaultSelector struct{}
+
+// Select implements Selector
+func (DefaultSelector) Select(tube string) (string, string, []interface{}) {
+	return "", reserveOrder, nil
+}
+
+// reserveQuery returns the query selecting the next n jobs to reserve from
+// tube, after skipping offset jobs, and its arguments
+func (q *Queue) reserveQuery(tube string, n, offset int) (string, []interface{}) {
+	sel := q.options.Selector
+	if sel == nil {
+		sel = DefaultSelector{}
+	}
+	where, orderBy, extra := sel.Select(tube)
+	if ordering := q.tubeOrdering(tube); ordering != "" {
+		orderBy = ordering
+	}
+	query := "SELECT " + jobColumns + " from simple_queue WHERE tube=? AND state=?"
+	if where != "" {
+		query += " AND (" + where + ")"
+	}
+	query += " ORDER BY " + orderBy + " LIMIT ? OFFSET ?"
+	return query, append(append([]interface{}{tube, STATE_READY}, extra...), n, offset)
+}
@@ -0,0 +1,39 @@
+package queue_test
+
+import (
+	"testing"
+
+	"github.com/bakins/simple-queue"
+)
+
+// lowestFirst reserves the lowest priority jobs first, skipping jobs with
+// priority above a limit
+type lowestFirst struct {
+	limit int
+}
+
+func (s lowestFirst) Select(tube string) (string, string, []interface{}) {
+	return "priority <= ?", "priority ASC, id ASC", []interface{}{s.limit}
+}
+
+func TestSelector(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 5, 600, []byte("high")))
+		ok(t, q.Put("test", 1, 600, []byte("low")))
+		ok(t, q.Put("test", 9, 600, []byte("too high")))
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("low"), j.Data)
+
+		j, err = q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("high"), j.Data)
+
+		j, err = q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j == nil, "job is not nil")
+	}, queue.WithSelector(lowestFirst{limit: 5}))
+}
@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// ShardedQueue routes operations across several queues
+type ShardedQueue struct {
+	shards []*Queue
+}
+
+// Shard copies the ready jobs of the queue into n new queues, assigning
+// each job to a shard by id. The shards are stored next to the queue's
+// file and opened with the same options. The original queue is left
+// unchanged. It fails if a shard file already has jobs, such as from an
+// earlier Shard.
+func (q *Queue) Shard(n int) ([]*Queue, error) {
+	if n <= 0 {
+		return nil, errors.New("number of shards must be positive")
+	}
+
+	shards := make([]*Queue, 0, n)
+	closeAll := func() {
+		for _, s := range shards {
+			s.Close()
+		}
+	}
+	for i := 0; i < n; i++ {
+		name := shardName(q.filename, i)
+		s, err := open(name, q.options)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		shards = append(shards, s)
+
+		var exists bool
+		if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM simple_queue)").Scan(&exists); err != nil {
+			closeAll()
+			return nil, err
+		}
+		if exists {
+			closeAll()
+			return nil, fmt.Errorf("shard %s already has jobs", name)
+		}
+	}
+
+	for i, s := range shards {
+		if err := q.copyShard(s, n, i); err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+	return shards, nil
+}
+
+// shardName returns the name of shard i of the queue stored in filename.
+// The suffix is added to the path, before the query of an SQLite URI.
+func shardName(filename string, i int) string {
+	path, query := filename, ""
+	if strings.HasPrefix(filename, "file:") {
+		if n := strings.IndexByte(filename, '?'); n >= 0 {
+			path, query = filename[:n], filename[n:]
+		}
+	}
+	return fmt.Sprintf("%s.shard%d%s", path, i, query)
+}
+
+// copyShard copies the ready jobs whose id modulo n is i into s
+func (q *Queue) copyShard(s *Queue, n, i int) error {
+	rows, err := q.db.Query(`SELECT tube, priority, created, modified, state, data, ttr, ttl, dedup_key, seq, metadata, trace_id
+                             FROM simple_queue WHERE state=? AND id % ? = ? ORDER BY id`, STATE_READY, n, i)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for rows.Next() {
+		var (
+			tube, data               []byte
+			created, modified, state int64
+			ttr, ttl, seq            int64
+			priority, key, metadata  interface{}
+			traceID                  interface{}
+		)
+		if err := rows.Scan(&tube, &priority, &created, &modified, &state, &data, &ttr, &ttl, &key, &seq, &metadata, &traceID); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT into simple_queue (tube, priority, created, modified, state, data, ttr, ttl, dedup_key, seq, metadata, trace_id) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			string(tube), priority, created, modified, state, data, ttr, ttl, key, seq, metadata, traceID)
+		if err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// JoinShards returns a ShardedQueue over queues, such as those returned
+// by Shard
+func JoinShards(queues []*Queue) *ShardedQueue {
+	return &ShardedQueue{shards: queues}
+}
+
+// shard returns the index of the shard for tube
+func (s *ShardedQueue) shard(tube string) int {
+	h := fnv.New32a()
+	h.Write([]byte(tube))
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
+
+// Put puts a job into the shard for tube
+func (s *ShardedQueue) Put(tube string, priority int, ttr int, data []byte, opts ...PutOption) error {
+	return s.shards[s.shard(tube)].Put(tube, priority, ttr, data, opts...)
+}
+
+// Reserve reserves a job from the shard for tube, waiting up to timeout
+// seconds. If that shard has no ready jobs, the other shards are tried
+// without waiting, as jobs for a tube may have been spread across shards
+// by Shard.
+func (s *ShardedQueue) Reserve(tube string, timeout int) (*Job, error) {
+	home := s.shard(tube)
+	j, err := s.shards[home].Reserve(tube, timeout)
+	if err != nil || j != nil {
+		return j, err
+	}
+	for i, shard := range s.shards {
+		if i == home {
+			continue
+		}
+		if j, err := shard.Reserve(tube, 0); err != nil || j != nil {
+			return j, err
+		}
+	}
+	return nil, nil
+}
+
+// Jobs returns the jobs in tube from all shards
+func (s *ShardedQueue) Jobs(tube string) ([]*Job, error) {
+	jobs := make([]*Job, 0)
+	for _, shard := range s.shards {
+		j, err := shard.Jobs(tube)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j...)
+	}
+	return jobs, nil
+}
+
+// Close closes all shards
+func (s *ShardedQueue) Close() error {
+	for _, shard := range s.shards {
+		shard.Close()
+	}
+	return nil
+}
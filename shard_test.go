@@ -0,0 +1,83 @@
+package queue_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestShard(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for i := 0; i < 100; i++ {
+			ok(t, q.Put(fmt.Sprintf("tube%d", i%3), 0, 600, []byte("testing")))
+		}
+
+		shards, err := q.Shard(4)
+		ok(t, err)
+		equals(t, 4, len(shards))
+
+		total := 0
+		for i, s := range shards {
+			defer os.Remove(fmt.Sprintf("%s.shard%d", q.Filename(), i))
+			count := 0
+			for _, tube := range []string{"tube0", "tube1", "tube2"} {
+				jobs, err := s.Jobs(tube)
+				ok(t, err)
+				count += len(jobs)
+			}
+			assert(t, count >= 20 && count <= 30, "shard %d has %d jobs", i, count)
+			total += count
+		}
+		equals(t, 100, total)
+
+		// the original is unchanged
+		jobs, err := q.Jobs("tube0")
+		ok(t, err)
+		equals(t, 34, len(jobs))
+
+		sq := queue.JoinShards(shards)
+		defer sq.Close()
+		jobs, err = sq.Jobs("tube0")
+		ok(t, err)
+		equals(t, 34, len(jobs))
+
+		for i := 0; i < 34; i++ {
+			j, err := sq.Reserve("tube0", 0)
+			ok(t, err)
+			assert(t, j != nil, "job is nil")
+		}
+		j, err := sq.Reserve("tube0", 0)
+		ok(t, err)
+		assert(t, j == nil, "job is not nil")
+
+		ok(t, sq.Put("new", 0, 600, []byte("new")))
+		j, err = sq.Reserve("new", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+
+		// sharding again would duplicate the jobs
+		_, err = q.Shard(4)
+		assert(t, err != nil, "expected error sharding into shards with jobs")
+	})
+}
+
+func TestShardURI(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+	q, err := queue.New("file:"+file+"?cache=shared", 4, 3)
+	ok(t, err)
+	defer q.Close()
+	ok(t, q.Put("test", 0, 600, []byte("testing")))
+
+	shards, err := q.Shard(2)
+	ok(t, err)
+	defer queue.JoinShards(shards).Close()
+	for i := range shards {
+		name := fmt.Sprintf("%s.shard%d", file, i)
+		defer os.Remove(name)
+		_, err := os.Stat(name)
+		ok(t, err)
+	}
+}
@@ -0,0 +1,301 @@
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TubeStats are job counts for a tube
+type TubeStats struct {
+	Tube     string
+	Ready    int64
+	Reserved int64
+	Delayed  int64
+	// MaxReadyAge is the age of the oldest ready job, or zero if there
+	// are none.
+	MaxReadyAge time.Duration
+}
+
+// MaxPriority returns the highest priority of the jobs in tube with state,
+// or zero if there are none
+func (q *Queue) MaxPriority(tube string, state int) (uint, error) {
+	var p uint
+	err := q.db.QueryRow("SELECT CAST(COALESCE(MAX(priority), 0) AS INTEGER) FROM simple_queue WHERE tube=? AND state=?", q.tubeName(tube), state).Scan(&p)
+	return p, err
+}
+
+// MinPriority returns the lowest priority of the jobs in tube with state,
+// or zero if there are none
+func (q *Queue) MinPriority(tube string, state int) (uint, error) {
+	var p uint
+	err := q.db.QueryRow("SELECT CAST(COALESCE(MIN(priority), 0) AS INTEGER) FROM simple_queue WHERE tube=? AND state=?", q.tubeName(tube), state).Scan(&p)
+	return p, err
+}
+
+// TubeStatEntry is the number of jobs in a tube
+type TubeStatEntry struct {
+	Tube  string
+	Count int64
+}
+
+// TopTubesBySize returns the n tubes with the most jobs in state, largest
+// first
+func (q *Queue) TopTubesBySize(n int, state int) ([]TubeStatEntry, error) {
+	if n < 1 {
+		return nil, errors.New("n must be at least 1")
+	}
+	rows, err := q.db.Query("SELECT tube, COUNT(*) AS cnt FROM simple_queue WHERE state=? GROUP BY tube ORDER BY cnt DESC, tube ASC LIMIT ?", state, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]TubeStatEntry, 0, n)
+	for rows.Next() {
+		var e TubeStatEntry
+		if err := rows.Scan(&e.Tube, &e.Count); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// tubeStatsColumns are the aggregates read by scanTubeStats, after the
+// tube name. They take tubeStatsArgs.
+const tubeStatsColumns = `COUNT(CASE WHEN state=? THEN 1 END), COUNT(CASE WHEN state=? THEN 1 END),
+                          COUNT(CASE WHEN state=? THEN 1 END), MIN(CASE WHEN state=? THEN created END)`
+
+var tubeStatsArgs = []interface{}{STATE_READY, STATE_RESERVED, STATE_DELAYED, STATE_READY}
+
+func (q *Queue) scanTubeStats(row scanner) (TubeStats, error) {
+	var stats TubeStats
+	var oldest sql.NullInt64
+	if err := row.Scan(&stats.Tube, &stats.Ready, &stats.Reserved, &stats.Delayed, &oldest); err != nil {
+		return stats, err
+	}
+	if oldest.Valid {
+		stats.MaxReadyAge = q.now().Sub(fromMillis(oldest.Int64))
+	}
+	return stats, nil
+}
+
+// TubeStats returns the job counts for a tube
+func (q *Queue) TubeStats(tube string) (TubeStats, error) {
+	tube = q.tubeName(tube)
+	args := append([]interface{}{tube}, tubeStatsArgs...)
+	row := q.db.QueryRow("SELECT ?, "+tubeStatsColumns+" FROM simple_queue WHERE tube=?", append(args, tube)...)
+	return q.scanTubeStats(row)
+}
+
+// TubeExists returns true if tube has any jobs
+func (q *Queue) TubeExists(tube string) (bool, error) {
+	var exists bool
+	err := q.db.QueryRow("SELECT EXISTS(SELECT 1 FROM simple_queue WHERE tube=? LIMIT 1)", q.tubeName(tube)).Scan(&exists)
+	return exists, err
+}
+
+// TubeHasReady returns true if tube has a ready job
+func (q *Queue) TubeHasReady(tube string) (bool, error) {
+	var exists bool
+	err := q.db.QueryRow("SELECT EXISTS(SELECT 1 FROM simple_queue WHERE tube=? AND state=? LIMIT 1)", q.tubeName(tube), STATE_READY).Scan(&exists)
+	return exists, err
+}
+
+// ForeachTube calls fn with the stats of each tube that has jobs, in
+// order of tube name. Iteration stops at the first error from fn, which
+// is returned. Stats are read in a single transaction while fn is called,
+// so fn should not modify the queue.
+func (q *Queue) ForeachTube(fn func(tube string, stats TubeStats) error) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT tube, "+tubeStatsColumns+" FROM simple_queue GROUP BY tube ORDER BY tube", tubeStatsArgs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		stats, err := q.scanTubeStats(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(stats.Tube, stats); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// TubeSummary describes the jobs in a tube
+type TubeSummary struct {
+	Tube     string
+	Ready    int64
+	Reserved int64
+	Buried   int64
+	Delayed  int64
+	// OldestCreated and NewestCreated are the creation times of the
+	// oldest and newest jobs in any state
+	OldestCreated time.Time
+	NewestCreated time.Time
+	AvgPriority   float64
+}
+
+// TubeSummary returns a summary of every tube that has jobs, in order of
+// tube name, using a single query
+func (q *Queue) TubeSummary() ([]TubeSummary, error) {
+	rows, err := q.db.Query(`SELECT tube, COUNT(CASE WHEN state=? THEN 1 END), COUNT(CASE WHEN state=? THEN 1 END),
+                             COUNT(CASE WHEN state=? THEN 1 END), COUNT(CASE WHEN state=? THEN 1 END),
+                             MIN(created), MAX(created), AVG(priority)
+                             FROM simple_queue GROUP BY tube ORDER BY tube`,
+		STATE_READY, STATE_RESERVED, STATE_BURIED, STATE_DELAYED)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []TubeSummary
+	for rows.Next() {
+		var s TubeSummary
+		var oldest, newest int64
+		if err := rows.Scan(&s.Tube, &s.Ready, &s.Reserved, &s.Buried, &s.Delayed, &oldest, &newest, &s.AvgPriority); err != nil {
+			return nil, err
+		}
+		s.OldestCreated = fromMillis(oldest)
+		s.NewestCreated = fromMillis(newest)
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// Fingerprint returns a hex encoded SHA-256 of the tube names, the number
+// of jobs in each state in each tube and the IDs of the jobs in each tube.
+// Queues with the same contents have the same fingerprint, so it can be
+// used to detect changes.
+func (q *Queue) Fingerprint() (string, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	h := sha256.New()
+	rows, err := tx.Query("SELECT tube, state, COUNT(*) FROM simple_queue GROUP BY tube, state ORDER BY tube, state")
+	if err != nil {
+		return "", err
+	}
+	for rows.Next() {
+		var tube string
+		var state, n int64
+		if err := rows.Scan(&tube, &state, &n); err != nil {
+			rows.Close()
+			return "", err
+		}
+		fmt.Fprintf(h, "%q %d %d\n", tube, state, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	rows, err = tx.Query("SELECT tube, id FROM simple_queue ORDER BY tube, id")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tube string
+		var id int64
+		if err := rows.Scan(&tube, &id); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%q %d\n", tube, id)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WatchStats sends the stats for a tube every interval. If the receiver
+// falls behind, the oldest reading is discarded. Calling the returned
+// function stops watching and closes the channel.
+func (q *Queue) WatchStats(tube string, interval time.Duration) (<-chan TubeStats, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan TubeStats, 1)
+
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.exit:
+				return
+			case <-ticker.C:
+			}
+
+			stats, err := q.TubeStats(tube)
+			if err != nil {
+				continue
+			}
+
+			select {
+			case ch <- stats:
+			default:
+				// drop the oldest reading. this is the only sender, so
+				// there is room afterwards.
+				select {
+				case <-ch:
+				default:
+				}
+				ch <- stats
+			}
+		}
+	}()
+
+	return ch, cancel
+}
+
+// WatchDepth sends the number of ready jobs in tube whenever it changes,
+// checking every interval. Calling the returned function stops watching
+// and closes the channel.
+func (q *Queue) WatchDepth(tube string, interval time.Duration) (<-chan int, func()) {
+	stats, cancel := q.WatchStats(tube, interval)
+	ch := make(chan int, 1)
+
+	go func() {
+		defer close(ch)
+		last := int64(-1)
+		for s := range stats {
+			if s.Ready == last {
+				continue
+			}
+			last = s.Ready
+			select {
+			case ch <- int(s.Ready):
+			default:
+				// replace the unread depth with the current one
+				select {
+				case <-ch:
+				default:
+				}
+				ch <- int(s.Ready)
+			}
+		}
+	}()
+
+	return ch, cancel
+}
@@ -0,0 +1,306 @@
+package queue_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestTubeStats(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("one")))
+		ok(t, q.Put("test", 0, 600, []byte("two")))
+		ok(t, q.Put("other", 0, 600, []byte("other")))
+		_, err := q.Reserve("test", 0)
+		ok(t, err)
+
+		stats, err := q.TubeStats("test")
+		ok(t, err)
+		equals(t, queue.TubeStats{Tube: "test", Ready: 1, Reserved: 1}, stats)
+	}, queue.WithClock(clock.Now))
+}
+
+func TestTubeStatsMaxReadyAge(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		stats, err := q.TubeStats("test")
+		ok(t, err)
+		equals(t, time.Duration(0), stats.MaxReadyAge)
+
+		ok(t, q.Put("test", 0, 600, []byte("old")))
+		clock.Advance(time.Minute)
+		ok(t, q.Put("test", 0, 600, []byte("new")))
+		clock.Advance(time.Minute)
+
+		stats, err = q.TubeStats("test")
+		ok(t, err)
+		equals(t, 2*time.Minute, stats.MaxReadyAge)
+
+		// reserved jobs are not counted
+		_, err = q.Reserve("test", 0)
+		ok(t, err)
+		stats, err = q.TubeStats("test")
+		ok(t, err)
+		equals(t, time.Minute, stats.MaxReadyAge)
+	}, queue.WithClock(clock.Now))
+}
+
+func TestPriorityRange(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		max, err := q.MaxPriority("test", queue.STATE_READY)
+		ok(t, err)
+		equals(t, uint(0), max)
+
+		for _, p := range []int{5, 0, 50, 10} {
+			ok(t, q.Put("test", p, 600, []byte("testing")))
+		}
+
+		max, err = q.MaxPriority("test", queue.STATE_READY)
+		ok(t, err)
+		equals(t, uint(50), max)
+		min, err := q.MinPriority("test", queue.STATE_READY)
+		ok(t, err)
+		equals(t, uint(0), min)
+
+		// the highest priority job is reserved first
+		_, err = q.Reserve("test", 0)
+		ok(t, err)
+		max, err = q.MaxPriority("test", queue.STATE_READY)
+		ok(t, err)
+		equals(t, uint(10), max)
+		min, err = q.MinPriority("test", queue.STATE_RESERVED)
+		ok(t, err)
+		equals(t, uint(50), min)
+	})
+}
+
+func TestTopTubesBySize(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		for tube, n := range map[string]int{"large": 10, "medium": 5, "small": 1} {
+			for i := 0; i < n; i++ {
+				ok(t, q.Put(tube, 0, 600, []byte("testing")))
+			}
+		}
+
+		top, err := q.TopTubesBySize(2, queue.STATE_READY)
+		ok(t, err)
+		equals(t, []queue.TubeStatEntry{{Tube: "large", Count: 10}, {Tube: "medium", Count: 5}}, top)
+
+		top, err = q.TopTubesBySize(2, queue.STATE_RESERVED)
+		ok(t, err)
+		equals(t, []queue.TubeStatEntry{}, top)
+
+		_, err = q.TopTubesBySize(0, queue.STATE_READY)
+		assert(t, err != nil, "expected error for n of 0")
+		_, err = q.TopTubesBySize(-1, queue.STATE_READY)
+		assert(t, err != nil, "expected error for negative n")
+	})
+}
+
+func TestTubeExists(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		check := func(exists, ready bool) {
+			e, err := q.TubeExists("test")
+			ok(t, err)
+			equals(t, exists, e)
+			r, err := q.TubeHasReady("test")
+			ok(t, err)
+			equals(t, ready, r)
+		}
+
+		check(false, false)
+
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		check(true, true)
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		check(true, false)
+
+		ok(t, j.Delete())
+		check(false, false)
+	})
+}
+
+func TestForeachTube(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.Put("a", 0, 600, []byte("a")))
+		ok(t, q.Put("b", 0, 600, []byte("b")))
+		ok(t, q.Put("b", 0, 600, []byte("b")))
+		ok(t, q.Put("c", 0, 600, []byte("c")))
+
+		seen := make(map[string]queue.TubeStats)
+		err := q.ForeachTube(func(tube string, stats queue.TubeStats) error {
+			_, dup := seen[tube]
+			assert(t, !dup, "tube %s seen twice", tube)
+			seen[tube] = stats
+			return nil
+		})
+		ok(t, err)
+		equals(t, map[string]queue.TubeStats{
+			"a": {Tube: "a", Ready: 1},
+			"b": {Tube: "b", Ready: 2},
+			"c": {Tube: "c", Ready: 1},
+		}, seen)
+
+		stop := errors.New("stop")
+		calls := 0
+		err = q.ForeachTube(func(tube string, stats queue.TubeStats) error {
+			calls++
+			return stop
+		})
+		equals(t, stop, err)
+		equals(t, 1, calls)
+	}, queue.WithClock(clock.Now))
+}
+
+func TestTubeSummary(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		start := clock.Now()
+		ok(t, q.Put("a", 10, 600, []byte("a")))
+		clock.Advance(time.Second)
+		ok(t, q.Put("a", 20, 600, []byte("a")))
+		clock.Advance(time.Second)
+		ok(t, q.Put("a", 30, 600, []byte("a")))
+		ok(t, q.Put("a", 40, 600, []byte("a"), queue.WithDelay(time.Hour)))
+		clock.Advance(time.Second)
+		ok(t, q.Put("b", 5, 600, []byte("b")))
+
+		// a has jobs reserved and buried, b's only job is reserved
+		j, err := q.Reserve("a", 0)
+		ok(t, err)
+		ok(t, j.Bury("failed"))
+		_, err = q.Reserve("a", 0)
+		ok(t, err)
+		_, err = q.Reserve("b", 0)
+		ok(t, err)
+
+		summary, err := q.TubeSummary()
+		ok(t, err)
+		equals(t, []queue.TubeSummary{
+			{
+				Tube:          "a",
+				Ready:         1,
+				Reserved:      1,
+				Buried:        1,
+				Delayed:       1,
+				OldestCreated: start,
+				NewestCreated: start.Add(2 * time.Second),
+				AvgPriority:   25,
+			},
+			{
+				Tube:          "b",
+				Reserved:      1,
+				OldestCreated: start.Add(3 * time.Second),
+				NewestCreated: start.Add(3 * time.Second),
+				AvgPriority:   5,
+			},
+		}, summary)
+	}, queue.WithClock(clock.Now))
+}
+
+func TestFingerprint(t *testing.T) {
+	fill := func(q *queue.Queue) string {
+		ok(t, q.Put("a", 0, 600, []byte("a")))
+		ok(t, q.Put("b", 0, 600, []byte("b")))
+		f, err := q.Fingerprint()
+		ok(t, err)
+		return f
+	}
+
+	withQ(t, func(other *queue.Queue, t *testing.T) {
+		withQ(t, func(q *queue.Queue, t *testing.T) {
+			f := fill(q)
+			equals(t, f, fill(other))
+			again, err := q.Fingerprint()
+			ok(t, err)
+			equals(t, f, again)
+
+			ok(t, q.Put("a", 0, 600, []byte("a")))
+			put, err := q.Fingerprint()
+			ok(t, err)
+			assert(t, put != f, "put did not change fingerprint")
+
+			_, err = q.Reserve("a", 0)
+			ok(t, err)
+			reserved, err := q.Fingerprint()
+			ok(t, err)
+			assert(t, reserved != put, "reserve did not change fingerprint")
+		})
+	})
+}
+
+func TestWatchStats(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ch, cancel := q.WatchStats("test", 10*time.Millisecond)
+
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		waitStats(t, ch, queue.TubeStats{Tube: "test", Ready: 1})
+
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		waitStats(t, ch, queue.TubeStats{Tube: "test", Reserved: 1})
+
+		ok(t, j.Delete())
+		waitStats(t, ch, queue.TubeStats{Tube: "test"})
+
+		cancel()
+		for range ch {
+		}
+	}, queue.WithClock(clock.Now))
+}
+
+// waitStats reads from ch until it receives exp
+func waitStats(t *testing.T, ch <-chan queue.TubeStats, exp queue.TubeStats) {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case stats := <-ch:
+			if stats == exp {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("did not receive stats %+v", exp)
+		}
+	}
+}
+
+func TestWatchDepth(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ch, cancel := q.WatchDepth("test", 10*time.Millisecond)
+		waitDepth(t, ch, 0)
+
+		ok(t, q.Put("test", 0, 600, []byte("one")))
+		ok(t, q.Put("test", 0, 600, []byte("two")))
+		waitDepth(t, ch, 2)
+
+		_, err := q.Reserve("test", 0)
+		ok(t, err)
+		waitDepth(t, ch, 1)
+
+		cancel()
+		for range ch {
+		}
+	})
+}
+
+// waitDepth reads from ch until it receives exp
+func waitDepth(t *testing.T, ch <-chan int, exp int) {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case depth := <-ch:
+			if depth == exp {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("did not receive depth %d", exp)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package queue
+
+import "database/sql"
+
+// stmt returns query prepared for use in tx. Statements are prepared once
+// on the database and reused until the queue is closed.
+func (q *Queue) stmt(tx *sql.Tx, query string) (*sql.Stmt, error) {
+	q.stmtLock.Lock()
+	defer q.stmtLock.Unlock()
+
+	if q.stmts == nil {
+		// caching is disabled or the queue is closed
+		return tx.Prepare(query)
+	}
+	s, ok := q.stmts[query]
+	if !ok {
+		var err error
+		if s, err = q.db.Prepare(query); err != nil {
+			return nil, err
+		}
+		q.stmts[query] = s
+	}
+	return tx.Stmt(s), nil
+}
+
+// closeStmts closes the cached statements and stops caching
+func (q *Queue) closeStmts() {
+	q.stmtLock.Lock()
+	defer q.stmtLock.Unlock()
+	for _, s := range q.stmts {
+		s.Close()
+	}
+	q.stmts = nil
+}
@@ -0,0 +1,60 @@
+package queue_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestStatementCache(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+	q, err := queue.New(file, 4, 3)
+	ok(t, err)
+
+	ok(t, q.Put("test", 0, 600, []byte("testing")))
+	j, err := q.Reserve("test", 0)
+	ok(t, err)
+	ok(t, j.Delete())
+	equals(t, 3, queue.CachedStatements(q))
+
+	// statements are reused
+	ok(t, q.Put("test", 0, 600, []byte("testing")))
+	equals(t, 3, queue.CachedStatements(q))
+
+	ok(t, q.Close())
+	equals(t, 0, queue.CachedStatements(q))
+}
+
+func benchmarkPutReserve(b *testing.B, cache bool) {
+	file := tempfile()
+	defer os.Remove(file)
+	// keep maintenance out of the measurements
+	q, err := queue.New(file, 4, 3600)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer q.Close()
+	if !cache {
+		queue.DisableStatementCache(q)
+	}
+
+	data := []byte("testing")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := q.Put("test", 0, 600, data); err != nil {
+			b.Fatal(err)
+		}
+		j, err := q.Reserve("test", 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := j.Delete(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPutReserveCached(b *testing.B)   { benchmarkPutReserve(b, true) }
+func BenchmarkPutReserveUncached(b *testing.B) { benchmarkPutReserve(b, false) }
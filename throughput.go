@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// throughputSeconds is the longest window Throughput can measure
+const throughputSeconds = 3600
+
+// throughput counts deleted jobs per second over the last hour
+type throughput struct {
+	sync.Mutex
+	// counts[i] is the number of deletions in the second seconds[i]
+	counts  [throughputSeconds]int64
+	seconds [throughputSeconds]int64
+}
+
+// record counts a deletion at t
+func (r *throughput) record(t time.Time) {
+	s := t.Unix()
+	i := s % throughputSeconds
+	r.Lock()
+	defer r.Unlock()
+	if r.seconds[i] != s {
+		r.seconds[i] = s
+		r.counts[i] = 0
+	}
+	r.counts[i]++
+}
+
+// Throughput returns the number of jobs deleted per second over the
+// window ending now. Windows longer than an hour are treated as an hour.
+// Deletions are counted in memory, so only those made through this Queue
+// since it was opened are included.
+func (q *Queue) Throughput(window time.Duration) float64 {
+	if window <= 0 {
+		return 0
+	}
+	if window > throughputSeconds*time.Second {
+		window = throughputSeconds * time.Second
+	}
+	now := q.now().Unix()
+	since := now - int64(window/time.Second)
+
+	r := &q.throughput
+	r.Lock()
+	defer r.Unlock()
+	var n int64
+	for i, s := range r.seconds {
+		if s > since && s <= now {
+			n += r.counts[i]
+		}
+	}
+	return float64(n) / window.Seconds()
+}
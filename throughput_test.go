@@ -0,0 +1,31 @@
+package queue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestThroughput(t *testing.T) {
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		equals(t, 0.0, q.Throughput(time.Minute))
+
+		for i := 0; i < 30; i++ {
+			ok(t, q.Put("test", 0, 600, []byte("testing")))
+			j, err := q.Reserve("test", 0)
+			ok(t, err)
+			ok(t, j.Delete())
+			clock.Advance(time.Second)
+		}
+
+		equals(t, 0.5, q.Throughput(time.Minute))
+		// the current second has no deletions yet
+		equals(t, 0.9, q.Throughput(10*time.Second))
+
+		clock.Advance(time.Minute)
+		equals(t, 0.0, q.Throughput(time.Minute))
+		equals(t, 30.0/3600, q.Throughput(2*time.Hour))
+	}, queue.WithClock(clock.Now))
+}
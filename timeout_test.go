@@ -0,0 +1,35 @@
+package queue_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestDefaultTimeout(t *testing.T) {
+	withDB(t, func(q *queue.Queue, db *sql.DB, t *testing.T) {
+		ok(t, q.Put("test", 0, 600, []byte("fast")))
+
+		// make inserts slow with a trigger counting a billion rows
+		_, err := db.Exec(`CREATE TABLE slow AS WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n LIMIT 1000) SELECT i FROM n`)
+		ok(t, err)
+		_, err = db.Exec(`CREATE TRIGGER slow_insert AFTER INSERT ON simple_queue BEGIN
+                            SELECT COUNT(*) FROM slow a, slow b, slow c;
+                          END`)
+		ok(t, err)
+
+		start := time.Now()
+		err = q.Put("test", 0, 600, []byte("slow"))
+		equals(t, context.DeadlineExceeded, err)
+		assert(t, time.Since(start) < 5*time.Second, "put was not interrupted")
+
+		// other statements are unaffected
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("fast"), j.Data)
+	}, queue.WithDefaultTimeout(50*time.Millisecond))
+}
@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TubeOptions configure a single tube. They are stored in the database by
+// EnsureTube and restored when the queue is opened.
+type TubeOptions struct {
+	// MaxCapacity is the maximum number of jobs in the tube. Zero is
+	// unlimited. Put returns ErrQueueFull when the tube is full.
+	MaxCapacity int
+	// DefaultTTR is used when Put is called with a ttr of zero.
+	DefaultTTR time.Duration
+	// DefaultPriority is used when Put is called with a priority of zero.
+	DefaultPriority int
+	// MaxInFlight is the maximum number of jobs from the tube reserved at
+	// once. Zero is unlimited. Reserve finds no job in a tube at its limit.
+	MaxInFlight int
+}
+
+// EnsureTube stores the options for tube, replacing any it already has.
+// They apply to every Put to the tube from now on, including after the
+// queue is reopened.
+func (q *Queue) EnsureTube(tube string, opts TubeOptions) error {
+	if err := q.writable(); err != nil {
+		return err
+	}
+	tube = q.tubeName(tube)
+	// an upsert keeps any ordering set by SetTubeOrdering
+	_, err := q.db.Exec(`INSERT INTO simple_queue_tubes (tube, max_capacity, default_ttr, default_priority, max_in_flight) VALUES(?, ?, ?, ?, ?)
+                         ON CONFLICT(tube) DO UPDATE SET max_capacity=excluded.max_capacity, default_ttr=excluded.default_ttr,
+                         default_priority=excluded.default_priority, max_in_flight=excluded.max_in_flight`,
+		tube, opts.MaxCapacity, toDurationMillis(opts.DefaultTTR), opts.DefaultPriority, opts.MaxInFlight)
+	if err != nil {
+		return err
+	}
+
+	q.tubeLock.Lock()
+	defer q.tubeLock.Unlock()
+	q.tubes[tube] = opts
+	return nil
+}
+
+// loadTubes reads the stored tube options
+func (q *Queue) loadTubes() error {
+	rows, err := q.db.Query("SELECT tube, max_capacity, default_ttr, default_priority, max_in_flight, ordering FROM simple_queue_tubes")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	q.tubeLock.Lock()
+	defer q.tubeLock.Unlock()
+	for rows.Next() {
+		var tube, ordering string
+		var opts TubeOptions
+		var ttr int64
+		if err := rows.Scan(&tube, &opts.MaxCapacity, &ttr, &opts.DefaultPriority, &opts.MaxInFlight, &ordering); err != nil {
+			return err
+		}
+		opts.DefaultTTR = fromDurationMillis(ttr)
+		q.tubes[tube] = opts
+		if ordering != "" {
+			q.orderings[tube] = ordering
+		}
+	}
+	return rows.Err()
+}
+
+// orderColumns are the columns SetTubeOrdering allows
+var orderColumns = map[string]bool{
+	"id":            true,
+	"created":       true,
+	"modified":      true,
+	"priority":      true,
+	"seq":           true,
+	"ttr":           true,
+	"ttl":           true,
+	"reserve_count": true,
+}
+
+// SetTubeOrdering sets the order in which Reserve takes jobs from tube,
+// in place of the Selector's, such as "created DESC" for last in, first
+// out. orderClause is a comma separated list of columns, each optionally
+// followed by ASC or DESC, and may start with ORDER BY. The columns
+// allowed are id, created, modified, priority, seq, ttr, ttl and
+// reserve_count. Ties are broken by id. An empty clause restores the
+// default ordering. The ordering is stored with the tube's options. Any
+// condition from the Selector still applies, so it should not be used with
+// a Selector whose ordering has placeholders.
+func (q *Queue) SetTubeOrdering(tube string, orderClause string) error {
+	if err := q.writable(); err != nil {
+		return err
+	}
+	ordering, err := parseOrdering(orderClause)
+	if err != nil {
+		return err
+	}
+	tube = q.tubeName(tube)
+	_, err = q.db.Exec(`INSERT INTO simple_queue_tubes (tube, ordering) VALUES(?, ?)
+                        ON CONFLICT(tube) DO UPDATE SET ordering=excluded.ordering`, tube, ordering)
+	if err != nil {
+		return err
+	}
+
+	q.tubeLock.Lock()
+	defer q.tubeLock.Unlock()
+	if ordering == "" {
+		delete(q.orderings, tube)
+	} else {
+		q.orderings[tube] = ordering
+	}
+	return nil
+}
+
+// parseOrdering validates an ordering for SetTubeOrdering and returns it
+// in a canonical form
+func parseOrdering(clause string) (string, error) {
+	fields := strings.Fields(clause)
+	if len(fields) >= 2 && strings.EqualFold(fields[0], "ORDER") && strings.EqualFold(fields[1], "BY") {
+		fields = fields[2:]
+	}
+	clause = strings.Join(fields, " ")
+	if clause == "" {
+		return "", nil
+	}
+
+	var terms []string
+	for _, term := range strings.Split(clause, ",") {
+		words := strings.Fields(term)
+		if len(words) == 0 || len(words) > 2 {
+			return "", fmt.Errorf("invalid ordering term: %q", term)
+		}
+		column := strings.ToLower(words[0])
+		if !orderColumns[column] {
+			return "", fmt.Errorf("column not allowed in ordering: %q", words[0])
+		}
+		dir := "ASC"
+		if len(words) == 2 {
+			dir = strings.ToUpper(words[1])
+			if dir != "ASC" && dir != "DESC" {
+				return "", fmt.Errorf("invalid ordering direction: %q", words[1])
+			}
+		}
+		terms = append(terms, column+" "+dir)
+	}
+	return strings.Join(terms, ", ") + ", id ASC", nil
+}
+
+// tubeOrdering returns the ordering set for tube by SetTubeOrdering, or
+// an empty string if it has none
+func (q *Queue) tubeOrdering(tube string) string {
+	q.tubeLock.RLock()
+	defer q.tubeLock.RUnlock()
+	return q.orderings[tube]
+}
+
+// tubeOptions returns the options for tube, which are zero if it has none
+func (q *Queue) tubeOptions(tube string) TubeOptions {
+	q.tubeLock.RLock()
+	defer q.tubeLock.RUnlock()
+	return q.tubes[tube]
+}
+
+// tubeName returns the name tube is stored under
+func (q *Queue) tubeName(tube string) string {
+	if q.options.TubeNameFunc == nil {
+		return tube
+	}
+	return q.options.TubeNameFunc(tube)
+}
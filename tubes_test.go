@@ -0,0 +1,203 @@
+package queue_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestEnsureTube(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+
+	q, err := queue.New(file, 4, 3)
+	ok(t, err)
+	ok(t, q.EnsureTube("test", queue.TubeOptions{
+		MaxCapacity:     2,
+		DefaultTTR:      90 * time.Second,
+		DefaultPriority: 7,
+	}))
+	ok(t, q.Close())
+
+	q, err = queue.New(file, 4, 3)
+	ok(t, err)
+	defer q.Close()
+
+	ok(t, q.Put("test", 0, 0, []byte("defaults")))
+	ok(t, q.Put("test", 3, 10, []byte("explicit")))
+	equals(t, queue.ErrQueueFull, q.Put("test", 0, 0, []byte("full")))
+	// other tubes are not affected
+	ok(t, q.Put("other", 0, 0, []byte("other")))
+
+	jobs, err := q.Jobs("test")
+	ok(t, err)
+	equals(t, 2, len(jobs))
+	for _, j := range jobs {
+		switch string(j.Data) {
+		case "defaults":
+			equals(t, uint(7), j.Priority)
+			equals(t, 90*time.Second, j.TTR)
+		case "explicit":
+			equals(t, uint(3), j.Priority)
+			equals(t, 10*time.Second, j.TTR)
+		}
+	}
+
+	jobs, err = q.Jobs("other")
+	ok(t, err)
+	equals(t, uint(0), jobs[0].Priority)
+	equals(t, time.Second, jobs[0].TTR)
+}
+
+func TestReserveAnyMaxInFlight(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		ok(t, q.EnsureTube("a", queue.TubeOptions{MaxInFlight: 1}))
+		ok(t, q.Put("a", 0, 600, []byte("a1")))
+		ok(t, q.Put("a", 0, 600, []byte("a2")))
+		ok(t, q.Put("b", 0, 600, []byte("b1")))
+
+		tubes := []string{"a", "b"}
+		held, err := q.ReserveAny(tubes, 0)
+		ok(t, err)
+		assert(t, held != nil, "job is nil")
+		equals(t, []byte("a1"), held.Data)
+
+		// a is at its limit so b is served
+		j, err := q.ReserveAny(tubes, 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("b1"), j.Data)
+
+		j, err = q.ReserveAny(tubes, 0)
+		ok(t, err)
+		assert(t, j == nil, "job is not nil")
+
+		ok(t, held.Delete())
+		j, err = q.ReserveAny(tubes, 0)
+		ok(t, err)
+		assert(t, j != nil, "job is nil")
+		equals(t, []byte("a2"), j.Data)
+	})
+}
+
+func TestTubeNameFunc(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+
+	tenant := func(name string) *queue.Queue {
+		q, err := queue.New(file, 4, 3, queue.WithTubeNameFunc(func(tube string) string {
+			return name + "/" + tube
+		}))
+		ok(t, err)
+		return q
+	}
+	a := tenant("a")
+	defer a.Close()
+	b := tenant("b")
+	defer b.Close()
+
+	ok(t, a.Put("jobs", 0, 600, []byte("a")))
+	ok(t, b.Put("jobs", 0, 600, []byte("b")))
+
+	jobs, err := a.Jobs("jobs")
+	ok(t, err)
+	equals(t, 1, len(jobs))
+	equals(t, "a/jobs", jobs[0].Tube)
+
+	j, err := b.Reserve("jobs", 0)
+	ok(t, err)
+	assert(t, j != nil, "job is nil")
+	equals(t, []byte("b"), j.Data)
+	j, err = b.Reserve("jobs", 0)
+	ok(t, err)
+	assert(t, j == nil, "tenant b reserved tenant a's job")
+
+	j, err = a.Reserve("jobs", 0)
+	ok(t, err)
+	assert(t, j != nil, "job is nil")
+	equals(t, []byte("a"), j.Data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := a.Notify(ctx, "jobs")
+	ok(t, err)
+	ok(t, b.Put("jobs", 0, 600, []byte("b")))
+	ok(t, a.Put("jobs", 0, 600, []byte("a")))
+	select {
+	case id := <-ch:
+		j, err := a.JobByID(id)
+		ok(t, err)
+		equals(t, "a/jobs", j.Tube)
+	case <-time.After(time.Second):
+		t.Fatal("no notification for namespaced tube")
+	}
+
+	// every method taking a tube uses the namespaced name
+	_, err = a.PutMulti(context.Background(), []queue.TubeJobSpec{{Tube: "multi", TTR: 600, Data: []byte("a")}})
+	ok(t, err)
+	ok(t, a.PutUpsert("upsert", "key", 0, 600, []byte("a")))
+	ok(t, a.Put("delayed", 0, 600, []byte("a"), queue.WithDelay(time.Hour)))
+	for _, tube := range []string{"multi", "upsert"} {
+		exists, err := a.TubeExists(tube)
+		ok(t, err)
+		assert(t, exists, "tube %s not found", tube)
+		exists, err = b.TubeExists(tube)
+		ok(t, err)
+		assert(t, !exists, "tenant b sees tenant a's tube %s", tube)
+	}
+	stats, err := a.TubeStats("jobs")
+	ok(t, err)
+	equals(t, int64(1), stats.Ready)
+	delayed, err := a.DelayedJobs("delayed")
+	ok(t, err)
+	equals(t, 1, len(delayed))
+
+	tube, err := a.Tube("jobs")
+	ok(t, err)
+	ok(t, tube.Drop())
+	n, err := tube.DataBytes()
+	ok(t, err)
+	equals(t, int64(0), n)
+	stats, err = b.TubeStats("jobs")
+	ok(t, err)
+	equals(t, int64(1), stats.Ready)
+}
+
+func TestSetTubeOrdering(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+	clock := newFakeClock()
+
+	q, err := queue.New(file, 4, 3, queue.WithClock(clock.Now))
+	ok(t, err)
+	ok(t, q.SetTubeOrdering("test", "ORDER BY created DESC"))
+	ok(t, q.EnsureTube("test", queue.TubeOptions{MaxCapacity: 10}))
+	for _, bad := range []string{"data DESC", "created DESC; DROP TABLE simple_queue", "created SIDEWAYS", "created,"} {
+		assert(t, q.SetTubeOrdering("test", bad) != nil, "expected error for %q", bad)
+	}
+	ok(t, q.Close())
+
+	// the ordering is kept when the queue is reopened
+	q, err = queue.New(file, 4, 3, queue.WithClock(clock.Now))
+	ok(t, err)
+	defer q.Close()
+
+	for _, data := range []string{"first", "second", "third"} {
+		ok(t, q.Put("test", 0, 600, []byte(data)))
+		ok(t, q.Put("other", 0, 600, []byte(data)))
+		clock.Advance(time.Second)
+	}
+
+	for _, exp := range []string{"third", "second", "first"} {
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		equals(t, []byte(exp), j.Data)
+	}
+	// other tubes keep the default ordering
+	j, err := q.Reserve("other", 0)
+	ok(t, err)
+	equals(t, []byte("first"), j.Data)
+}
@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// SignatureHeader is the header NotifyHTTP uses for the hex encoded
+// HMAC-SHA256 of the request body
+const SignatureHeader = "X-Queue-Signature"
+
+// webhookEvents are the events that can be sent by NotifyHTTP
+var webhookEvents = map[string]bool{
+	eventPut:     true,
+	eventReserve: true,
+	eventDelete:  true,
+	eventBury:    true,
+	eventExpire:  true,
+}
+
+// WebhookEvent is the JSON body sent by NotifyHTTP
+type WebhookEvent struct {
+	Event     string    `json:"event"`
+	JobID     int       `json:"jobID"`
+	Tube      string    `json:"tube"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// HTTPNotifier posts job events to a URL. Create one with NotifyHTTP.
+type HTTPNotifier struct {
+	url    string
+	secret []byte
+	client *http.Client
+	events chan WebhookEvent
+	remove func()
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NotifyHTTP posts a WebhookEvent to url for each job event in events,
+// which may include "put", "reserve", "delete", "bury" and "expire". Each
+// request is signed with secret in SignatureHeader. Events are delivered
+// in order by a single goroutine and are dropped if delivery falls behind.
+// Failed deliveries are not retried.
+func (q *Queue) NotifyHTTP(url string, events []string, secret string) (*HTTPNotifier, error) {
+	if err := checkWebhookURL(url); err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool, len(events))
+	for _, e := range events {
+		if !webhookEvents[e] {
+			return nil, fmt.Errorf("unknown event: %s", e)
+		}
+		want[e] = true
+	}
+
+	n := &HTTPNotifier{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: 10 * time.Second},
+		events: make(chan WebhookEvent, 64),
+		done:   make(chan struct{}),
+	}
+	n.remove = q.addHook(func(e jobEvent) {
+		if !want[e.Event] {
+			return
+		}
+		select {
+		case n.events <- WebhookEvent{Event: e.Event, JobID: e.JobID, Tube: e.Tube, Timestamp: e.Time}:
+		default:
+		}
+	})
+	go n.run()
+	return n, nil
+}
+
+func checkWebhookURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported webhook url: %s", s)
+	}
+	return nil
+}
+
+func (n *HTTPNotifier) run() {
+	for {
+		select {
+		case <-n.done:
+			return
+		case e := <-n.events:
+			n.send(e)
+		}
+	}
+}
+
+func (n *HTTPNotifier) send(e WebhookEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(n.secret, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Stop stops sending events. Events not yet sent are discarded.
+func (n *HTTPNotifier) Stop() {
+	n.once.Do(func() {
+		n.remove()
+		close(n.done)
+	})
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body using secret, as sent
+// in SignatureHeader
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
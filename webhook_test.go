@@ -0,0 +1,102 @@
+package queue_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bakins/simple-queue"
+)
+
+func TestNotifyHTTP(t *testing.T) {
+	type delivery struct {
+		body      []byte
+		signature string
+	}
+	deliveries := make(chan delivery, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		deliveries <- delivery{body: body, signature: r.Header.Get(queue.SignatureHeader)}
+	}))
+	defer srv.Close()
+
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		n, err := q.NotifyHTTP(srv.URL, []string{"put", "delete"}, "secret")
+		ok(t, err)
+
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		j, err := q.Reserve("test", 0)
+		ok(t, err)
+		ok(t, j.Delete())
+
+		for _, event := range []string{"put", "delete"} {
+			select {
+			case d := <-deliveries:
+				mac := hmac.New(sha256.New, []byte("secret"))
+				mac.Write(d.body)
+				equals(t, hex.EncodeToString(mac.Sum(nil)), d.signature)
+
+				var e queue.WebhookEvent
+				ok(t, json.Unmarshal(d.body, &e))
+				equals(t, event, e.Event)
+				equals(t, j.ID, e.JobID)
+				equals(t, "test", e.Tube)
+				assert(t, !e.Timestamp.IsZero(), "timestamp not set")
+			case <-time.After(2 * time.Second):
+				t.Fatalf("did not receive %s event", event)
+			}
+		}
+
+		n.Stop()
+		ok(t, q.Put("test", 0, 600, []byte("testing")))
+		select {
+		case d := <-deliveries:
+			t.Fatalf("unexpected delivery after stop: %s", d.body)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
+
+func TestNotifyHTTPExpire(t *testing.T) {
+	events := make(chan queue.WebhookEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e queue.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer srv.Close()
+
+	clock := newFakeClock()
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		n, err := q.NotifyHTTP(srv.URL, []string{"expire"}, "secret")
+		ok(t, err)
+		defer n.Stop()
+
+		ok(t, q.Put("test", 0, 600, []byte("testing"), queue.WithTTL(time.Second)))
+		clock.Advance(2 * time.Second)
+		ok(t, q.Maintanence())
+
+		select {
+		case e := <-events:
+			equals(t, "expire", e.Event)
+			equals(t, "test", e.Tube)
+		case <-time.After(2 * time.Second):
+			t.Fatal("did not receive expire event")
+		}
+	}, queue.WithClock(clock.Now))
+}
+
+func TestNotifyHTTPInvalid(t *testing.T) {
+	withQ(t, func(q *queue.Queue, t *testing.T) {
+		_, err := q.NotifyHTTP("http://localhost/", []string{"explode"}, "secret")
+		assert(t, err != nil, "expected error for unknown event")
+		_, err = q.NotifyHTTP("ftp://localhost/", []string{"put"}, "secret")
+		assert(t, err != nil, "expected error for unsupported url")
+	})
+}